@@ -0,0 +1,115 @@
+package lzo
+
+import "time"
+
+// adaptiveTrials is how many blocks an AdaptiveCompressor compresses with
+// both algorithms before choosing one
+const adaptiveTrials = 4
+
+// An AdaptiveCompressor chooses between Lzo1x_1 and Lzo1x_999 by trying
+// both on the first few blocks it compresses.  Once the trials are over it
+// keeps Lzo1x_1 if that already reaches TargetRatio, or if Lzo1x_999 takes
+// longer than the per-block deadline; otherwise it keeps Lzo1x_999.  Both
+// produce lzo1x data, so the output decompresses with any lzo1x
+// Compressor whichever was chosen.
+//
+// Like a Compressor, an AdaptiveCompressor must not be used from several
+// goroutines at once.
+type AdaptiveCompressor struct {
+	// TargetRatio is the uncompressed to compressed size ratio that is
+	// good enough to stay with the faster algorithm.  NewAdaptiveCompressor
+	// sets it to 2.
+	TargetRatio float64
+
+	deadline time.Duration
+	fast     *Compressor
+	best     *Compressor
+	chosen   *Compressor
+
+	trials   int
+	in       int
+	fastOut  int
+	bestTime time.Duration
+}
+
+// NewAdaptiveCompressor returns an AdaptiveCompressor that allows lzo1x_999
+// up to deadline per block.  Without lzo1x_999 support it always uses
+// lzo1x_1.
+func NewAdaptiveCompressor(deadline time.Duration) (*AdaptiveCompressor, error) {
+
+	fast, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AdaptiveCompressor{
+		TargetRatio: 2,
+		deadline:    deadline,
+		fast:        fast,
+	}
+
+	if isSupported(Lzo1x_999) {
+		a.best, _ = NewCompressor(Lzo1x_999)
+	} else {
+		a.chosen = fast
+	}
+
+	return a, nil
+}
+
+// Compress compresses b with the chosen algorithm.  During the trials it
+// compresses b with both and returns the smaller result.
+func (a *AdaptiveCompressor) Compress(b []byte) ([]byte, error) {
+
+	if a.chosen != nil {
+		return a.chosen.Compress(b)
+	}
+
+	fast, err := a.fast.Compress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	best, err := a.best.Compress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	a.trials++
+	a.in += len(b)
+	a.fastOut += len(fast)
+	a.bestTime += time.Since(start)
+
+	if a.trials == adaptiveTrials {
+		a.choose()
+	}
+
+	if len(best) < len(fast) {
+		return best, nil
+	}
+	return fast, nil
+}
+
+// Algorithm returns the algorithm the AdaptiveCompressor settled on, and
+// false while it is still trying both.
+func (a *AdaptiveCompressor) Algorithm() (LzoAlgorithm, bool) {
+	if a.chosen == nil {
+		return 0, false
+	}
+	return a.chosen.level, true
+}
+
+func (a *AdaptiveCompressor) choose() {
+
+	fastRatio := float64(a.in) / float64(a.fastOut)
+
+	switch {
+	case fastRatio >= a.TargetRatio:
+		a.chosen = a.fast
+	case a.bestTime/adaptiveTrials > a.deadline:
+		a.chosen = a.fast
+	default:
+		a.chosen = a.best
+	}
+}
@@ -0,0 +1,964 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"unsafe"
+)
+
+type LzoAlgorithm int
+
+// Lzo2a_999 usually compresses better than Lzo1x_999, but its output
+// decompresses noticeably slower than the lzo1x family. Avoid it for
+// latency-sensitive reads.
+const (
+	Lzo1x_1 LzoAlgorithm = iota
+	Lzo1x_999
+	Lzo2a_999
+	BestSpeed          = Lzo1x_1
+	BestCompression    = Lzo1x_999
+	DefaultCompression = Lzo1x_999
+)
+
+var algorithmNames = map[LzoAlgorithm]string{
+	Lzo1x_1:   "lzo1x_1",
+	Lzo1x_999: "lzo1x_999",
+	Lzo2a_999: "lzo2a_999",
+}
+
+func (a LzoAlgorithm) String() string {
+	s := algorithmNames[a]
+	if s == "" {
+		return fmt.Sprintf("LzoAlgorithm(%d)", int(a))
+	}
+	return s
+}
+
+// algorithms lists every algorithm the package knows about
+var algorithms = []LzoAlgorithm{Lzo1x_1, Lzo1x_999, Lzo2a_999}
+
+// supported records the algorithms that can both compress and decompress in
+// this build.  Probing allocates each algorithm's work memory, so it waits
+// until something first asks.
+var (
+	supportedOnce sync.Once
+	supported     map[LzoAlgorithm]bool
+)
+
+func isSupported(algorithm LzoAlgorithm) bool {
+	supportedOnce.Do(func() { supported = probeAlgorithms() })
+	return supported[algorithm]
+}
+
+// SupportedAlgorithms returns the algorithms that can both compress and
+// decompress with the linked liblzo2, or with the pure Go implementation
+// when built without cgo.
+func SupportedAlgorithms() []LzoAlgorithm {
+	var s []LzoAlgorithm
+	for _, a := range algorithms {
+		if isSupported(a) {
+			s = append(s, a)
+		}
+	}
+	return s
+}
+
+// probe checks that z can round trip a small buffer
+func probe(z *Compressor) bool {
+	return roundTrip(z, []byte("lzo probe lzo probe lzo probe lzo probe")) == nil
+}
+
+// roundTrip compresses in with z and checks it decompresses back to in
+func roundTrip(z *Compressor, in []byte) error {
+
+	c, err := z.Compress(in)
+	if err != nil {
+		return err
+	}
+
+	out := make([]byte, len(in))
+	out_size, errno := z.decompress_safe(c, out)
+	if errno != 0 {
+		return Errno(errno)
+	}
+
+	if !bytes.Equal(in, out[:out_size]) {
+		return errors.New("decompressed data differs")
+	}
+
+	return nil
+}
+
+// SelfTest compresses and decompresses a fixed buffer with every supported
+// algorithm and checks the result, as a health check that the linked
+// liblzo2 works on this platform.
+func SelfTest() error {
+
+	// text-like runs, which compress well, followed by noise, which
+	// doesn't
+	in := make([]byte, 64*1024)
+	for i := 0; i < len(in)/2; i++ {
+		in[i] = "the quick brown fox jumps over the lazy dog "[i%44]
+	}
+	x := uint32(1)
+	for i := len(in) / 2; i < len(in); i++ {
+		x = x*1664525 + 1013904223
+		in[i] = byte(x >> 24)
+	}
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err == nil {
+			err = roundTrip(z, in)
+		}
+		if err != nil {
+			return fmt.Errorf("lzo: self test of %v failed: %w", a, err)
+		}
+	}
+
+	return nil
+}
+
+// A Decompressor decompresses data from one algorithm.  It holds no work
+// memory, so unlike a Compressor it is cheap to create and safe for
+// concurrent use.  A Compressor has all of a Decompressor's methods.
+type Decompressor struct {
+	// MaxRatio limits how many times the size of its input SmartDecompress
+	// lets data expand to.  0 means DefaultMaxRatio.
+	MaxRatio int
+
+	level LzoAlgorithm
+	// the decompressors fill at most len(o) bytes and return the size of
+	// the output and an errno by value, so decompressing doesn't allocate
+	decompress func([]byte, []byte) (uint, int)
+	// decompress_safe never writes outside of its output buffer
+	decompress_safe func([]byte, []byte) (uint, int)
+}
+
+// NewDecompressor returns a Decompressor for the given algorithm.  It
+// needs only the algorithm's decompressors, so it may succeed where
+// NewCompressor doesn't, as for Lzo1x_999 without cgo.
+func NewDecompressor(algorithm LzoAlgorithm) (*Decompressor, error) {
+
+	z, err := newDecompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &z, nil
+}
+
+// A Compressor keeps its work memory between calls, so it must not be used
+// from several goroutines at once.  Use Clone to get one per goroutine.
+type Compressor struct {
+	Decompressor
+	compress    func([]byte, []byte, *int, []byte) int
+	output_size func(int) int
+	wrkmem_len  int
+	wrkmem      []byte
+	// pooled is set when wrkmem came from the package's pool
+	pooled bool
+	// out is CompressTo's output buffer, kept for the next call
+	out []byte
+	// compress_level is the lzo1x_999 level set by NewCompressorLevel
+	compress_level int
+}
+
+// NewCompressorLevel returns an Lzo1x_999 Compressor that uses the given
+// compression level, from 1 (fastest) to 9 (smallest output).  Lzo1x_999
+// on its own uses level 8.
+//
+// The level sets how hard liblzo searches for matches.  At every level it
+// follows a hash chain of earlier candidates, keeping the longest match,
+// until the chain limit or a match of the level's nice length stops it.
+// Levels 1 to 3 follow 4, 8 and 16 candidates with nice lengths of 8, 16
+// and 32, and don't look ahead.  4 to 6 follow 16, 32 and 128, stopping at
+// 16, 32 and 128 bytes, and also try a lazy match at the next byte.  7 to
+// 9 follow 256, 2048 and 4096, stopping at 128, 2048 and 2048 bytes, try
+// lazy matches up to two bytes on, and at 8 and 9 also weigh shorter
+// matches at nearer offsets.  Each step up costs more time for a smaller
+// gain, and how much depends on the data, so measure on a sample of your
+// own before choosing.  Decompression speed is the same for every level.
+func NewCompressorLevel(level int) (*Compressor, error) {
+
+	if level < 1 || level > 9 {
+		return nil, fmt.Errorf("lzo: invalid compression level: %d", level)
+	}
+
+	if !isSupported(Lzo1x_999) {
+		return nil, UnsupportedAlgorithmError(Lzo1x_999)
+	}
+
+	z, err := NewCompressor(Lzo1x_999)
+	if err != nil {
+		return nil, err
+	}
+
+	z.compress = lzo1x_999_compress_level(level)
+	z.compress_level = level
+
+	return z, nil
+}
+
+// useDict makes z decompress data compressed with dict as a preset
+// dictionary, and for Lzo1x_999 compress that way too.  The other lzo1x
+// algorithms can't compress with a dictionary.
+func (z *Compressor) useDict(dict []byte) error {
+
+	switch z.level {
+	case Lzo1x_999:
+		z.compress = lzo1x_999_compress_dict(z.Level(), dict)
+	case Lzo1x_1:
+	default:
+		return fmt.Errorf("lzo: %v does not support preset dictionaries", z.level)
+	}
+
+	z.decompress = lzo1x_decompress_dict_safe(dict)
+	z.decompress_safe = z.decompress
+
+	return nil
+}
+
+// Algorithm returns the algorithm z uses.
+func (z *Decompressor) Algorithm() LzoAlgorithm {
+	return z.level
+}
+
+// Level returns the lzo1x_999 compression level z uses, 8 unless set by
+// NewCompressorLevel.  Algorithms without levels return 0.
+func (z *Compressor) Level() int {
+	switch {
+	case z.compress_level != 0:
+		return z.compress_level
+	case z.level == Lzo1x_999:
+		return 8
+	}
+	return 0
+}
+
+// WorkMemSize returns the number of bytes of work memory a Compressor for
+// algorithm uses, the smallest buffer NewCompressorWithWorkmem accepts.
+// It returns 0 for an algorithm this build doesn't support, and for the
+// pure Go lzo1x_1, which needs none.
+func WorkMemSize(algorithm LzoAlgorithm) int {
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return 0
+	}
+
+	return z.wrkmem_len
+}
+
+// NewCompressorWithWorkmem returns a Compressor for the given algorithm
+// that compresses using wrkmem as its work memory.  wrkmem may be larger
+// than the algorithm needs; the extra space is harmless but unused, since
+// liblzo fixes the size of lzo1x_1's hash table when it is built.
+func NewCompressorWithWorkmem(level LzoAlgorithm, wrkmem []byte) (*Compressor, error) {
+
+	z, err := NewCompressor(level)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrkmem) < z.wrkmem_len {
+		return nil, fmt.Errorf("lzo: work memory of %d bytes is smaller than the %d %v needs", len(wrkmem), z.wrkmem_len, level)
+	}
+
+	z.wrkmem = wrkmem
+
+	return z, nil
+}
+
+// Clone returns a new Compressor using the same algorithm as z, with its own
+// work memory.
+func (z *Compressor) Clone() *Compressor {
+	c := *z
+	c.wrkmem = nil
+	c.pooled = false
+	c.out = nil
+	return &c
+}
+
+// Close returns z's work memory to a pool shared by every Compressor, for
+// the next one that needs memory of the same size.  z can still be used
+// afterwards, taking fresh work memory on its next Compress.  Work memory
+// passed to NewCompressorWithWorkmem is left to the caller.
+func (z *Compressor) Close() error {
+	if z.pooled {
+		putWorkmem(z.wrkmem)
+	}
+	z.wrkmem = nil
+	z.pooled = false
+	return nil
+}
+
+// Compress compresses a byte array and returns the compressed stream.  b is
+// handed to the compressor in place, without being copied.
+//
+// The output is deterministic: the same input, algorithm and LZO library
+// version always give the same bytes.  Other library versions, or the pure
+// Go lzo1x_1, may produce different output that decompresses the same;
+// record Version if byte-identical output matters.
+func (z *Compressor) Compress(b []byte) ([]byte, error) {
+
+	// our output buffer, sized to contain a worst-case compression
+	out_size := z.output_size(len(b))
+	if !lzo_uint_fits(len(b)) || !lzo_uint_fits(out_size) {
+		return nil, ErrLength
+	}
+
+	return z.compressTo(b, make([]byte, out_size))
+}
+
+// compressTo compresses b into out, which must be large enough for the
+// worst case, and returns the slice of out holding the compressed data
+func (z *Compressor) compressTo(b []byte, out []byte) ([]byte, error) {
+
+	// taken on first use, so decompression-only users never pay for it
+	if z.wrkmem == nil {
+		z.wrkmem = getWorkmem(z.wrkmem_len)
+		z.pooled = true
+	}
+
+	var out_size int
+	err := z.compress(b, out, &out_size, z.wrkmem)
+
+	// compression failed :(
+	if err != 0 {
+		return out[0:out_size], Errno(err)
+	}
+
+	return out[0:out_size], nil
+}
+
+// CompressMany compresses each of srcs, as Compress would, for batches of
+// small records.  The results share a single allocation, sized up front
+// for the worst case of every record, so the cost of a batch doesn't grow
+// with the number of allocations.
+func (z *Compressor) CompressMany(srcs [][]byte) ([][]byte, error) {
+
+	total := 0
+	for _, src := range srcs {
+		out_size := z.output_size(len(src))
+		if !lzo_uint_fits(len(src)) || !lzo_uint_fits(out_size) || total > math.MaxInt-out_size {
+			return nil, ErrLength
+		}
+		total += out_size
+	}
+
+	all := make([]byte, total)
+	out := make([][]byte, len(srcs))
+	start := 0
+
+	for i, src := range srcs {
+		end := start + z.output_size(len(src))
+		c, err := z.compressTo(src, all[start:end])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = c[:len(c):len(c)]
+		start += len(c)
+	}
+
+	return out, nil
+}
+
+// CompressString is like Compress([]byte(s)) without copying s: the
+// compressor is handed the string's own bytes, which it only reads and
+// doesn't keep once CompressString returns.
+func (z *Compressor) CompressString(s string) ([]byte, error) {
+	return z.Compress(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// CompressTo compresses src and writes the result to w as a single block,
+// with no framing, returning the number of bytes written.  The output
+// buffer is kept in z and reused, so unlike Compress a series of calls
+// doesn't allocate a new one each time.
+func (z *Compressor) CompressTo(w io.Writer, src []byte) (int, error) {
+
+	out_size := z.output_size(len(src))
+	if !lzo_uint_fits(len(src)) || !lzo_uint_fits(out_size) {
+		return 0, ErrLength
+	}
+	if len(z.out) < out_size {
+		z.out = make([]byte, out_size)
+	}
+
+	c, err := z.compressTo(src, z.out)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.Write(c)
+}
+
+// CompressMax compresses src and reports whether the result fits in maxOut
+// bytes.  When it doesn't, out is nil and fit false, so the caller can
+// store src uncompressed instead.
+func (z *Compressor) CompressMax(src []byte, maxOut int) (out []byte, fit bool, err error) {
+
+	out, err = z.Compress(src)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(out) > maxOut {
+		return nil, false, nil
+	}
+
+	return out, true, nil
+}
+
+// CompressOptimized compresses src, which must be lzo1x, and then runs
+// liblzo's lzo1x_optimize over the result, as the library's examples do
+// after lzo1x_999.  The optimizer rewrites some instructions in place so
+// the data decompresses a little faster; its size and what it decompresses
+// to are unchanged.  Without cgo there is no optimizer, and the output is
+// what Compress returns.
+func (z *Compressor) CompressOptimized(src []byte) ([]byte, error) {
+
+	if z.level != Lzo1x_1 && z.level != Lzo1x_999 {
+		return nil, fmt.Errorf("lzo: %v can't be optimized", z.level)
+	}
+
+	out, err := z.Compress(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// lzo1x_optimize decompresses as it goes, with no bounds checks, but
+	// the data is our own
+	scratch := make([]byte, len(src))
+	out_size, errno := lzo1x_optimize(out, scratch)
+	if errno != 0 {
+		return nil, fmt.Errorf("lzo: optimizing compressed data: %w", Errno(errno))
+	}
+	if out_size != uint(len(src)) {
+		return nil, errCorrupt
+	}
+
+	return out, nil
+}
+
+// estimateSample is how much of each part of its input EstimateRatio
+// compresses
+const estimateSample = 4 << 10
+
+// EstimateRatio guesses how well data would compress with Lzo1x_1, as the
+// compressed size over the original size, by compressing only its first
+// and middle 4 KiB.  A result near or above 1 means compressing is
+// unlikely to be worth it.  It is a heuristic: data whose samples aren't
+// representative of the rest may compress much better or worse than
+// estimated.  Empty data returns 1.
+func EstimateRatio(data []byte) float64 {
+
+	if len(data) == 0 {
+		return 1
+	}
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		return 1
+	}
+	defer z.Close()
+
+	samples := [][]byte{data}
+	if len(data) > 2*estimateSample {
+		mid := len(data)/2 - estimateSample/2
+		samples = [][]byte{data[:estimateSample], data[mid : mid+estimateSample]}
+	}
+
+	in, out := 0, 0
+	for _, s := range samples {
+		c, err := z.Compress(s)
+		if err != nil {
+			return 1
+		}
+		in += len(s)
+		out += len(c)
+	}
+
+	return float64(out) / float64(in)
+}
+
+// CompressVerified is like Compress but then decompresses the result and
+// checks it against src, returning an error rather than output that won't
+// round trip.  It roughly doubles the cost of compressing.  The check
+// always uses the bounds-checked decompressor, so even broken output can't
+// make the verification itself write out of bounds.
+func (z *Compressor) CompressVerified(src []byte) ([]byte, error) {
+
+	out, err := z.Compress(src)
+	if err != nil {
+		return nil, err
+	}
+
+	check := make([]byte, len(src))
+	out_size, errno := z.decompress_safe(out, check)
+	if errno != 0 {
+		return nil, fmt.Errorf("lzo: verifying compressed data: %w", Errno(errno))
+	}
+	if out_size != uint(len(src)) || !bytes.Equal(check, src) {
+		return nil, errors.New("lzo: compressed data does not decompress to the input")
+	}
+
+	return out, nil
+}
+
+// Decompress decompresses the byte array b passed in into the byte array o, and returns the size of the valid uncompressed data.
+// If o is not large enough to hold the  compressed data, an error is returned.
+// Decompress uses the library's fast decompressor, which trusts its input:
+// corrupt data can make it write past the end of o, and the size returned
+// with an error can't be relied on.  Use DecompressSafe for untrusted data.
+// When the size of o isn't known, CompressWithLength and
+// DecompressWithLength carry it along with the data.
+func (z *Decompressor) Decompress(b []byte, o []byte) (uint, error) {
+
+	// the fast decompressor would read past the end of empty input
+	if len(b) == 0 {
+		return 0, ErrInputOverrun
+	}
+
+	if !lzo_uint_fits(len(b)) || !lzo_uint_fits(len(o)) {
+		return 0, ErrLength
+	}
+
+	out_size, err := z.decompress(b, o)
+
+	// decompression failed :(
+	if err != 0 {
+		return out_size, Errno(err)
+	}
+
+	return out_size, nil
+}
+
+// DecompressSafe is like Decompress but uses the bounds-checked
+// decompressor, which never reads outside b or writes outside o.  When o is
+// too small it returns ErrOutputOverrun along with the number of bytes that
+// were decoded before running out of room; o holds that much valid
+// uncompressed data, so the caller can use or discard the partial output.
+func (z *Decompressor) DecompressSafe(b []byte, o []byte) (uint, error) {
+
+	if len(b) == 0 {
+		return 0, ErrInputOverrun
+	}
+
+	if !lzo_uint_fits(len(b)) || !lzo_uint_fits(len(o)) {
+		return 0, ErrLength
+	}
+
+	out_size, err := z.decompress_safe(b, o)
+
+	if err != 0 {
+		return out_size, Errno(err)
+	}
+
+	return out_size, nil
+}
+
+// DecompressAt is DecompressSafe into dst[off:], for decompressing into
+// part of a larger buffer such as a memory-mapped file.  Nothing outside
+// dst[off:] is written.  Like any Go slice, dst may be backed by memory
+// from outside the Go heap.
+func (z *Decompressor) DecompressAt(src []byte, dst []byte, off int) (uint, error) {
+
+	if off < 0 || off > len(dst) {
+		return 0, ErrLength
+	}
+
+	return z.DecompressSafe(src, dst[off:])
+}
+
+// DecompressLenient is like DecompressSafe for streams from encoders that
+// leave off the end-of-stream marker.  dst must be exactly the expected
+// uncompressed size: if the input runs out without an end marker once dst
+// has been filled, the data is returned as decoded, with missingEOF set,
+// instead of as an error.
+func (z *Decompressor) DecompressLenient(src []byte, dst []byte) (n uint, missingEOF bool, err error) {
+
+	n, err = z.DecompressSafe(src, dst)
+
+	// liblzo reports running out of input, the pure Go decoder a missing
+	// end marker
+	if (err == ErrEofNotFound || err == ErrInputOverrun) && len(src) > 0 && n == uint(len(dst)) {
+		return n, true, nil
+	}
+
+	return n, false, err
+}
+
+// DecompressMany decompresses each of srcs, which must decompress to
+// exactly the corresponding entry of dstLens bytes, using the safe
+// decompressor.  The results share a single allocation.  A record that
+// fails stops the batch: the records before it are returned, along with a
+// *BlockError giving its index.
+func (z *Decompressor) DecompressMany(srcs [][]byte, dstLens []int) ([][]byte, error) {
+
+	if len(dstLens) != len(srcs) {
+		return nil, errors.New("lzo: DecompressMany needs a length for each record")
+	}
+
+	total := 0
+	for _, n := range dstLens {
+		if n < 0 {
+			return nil, ErrLength
+		}
+		total += n
+	}
+
+	all := make([]byte, total)
+	out := make([][]byte, 0, len(srcs))
+
+	for i, src := range srcs {
+		n := dstLens[i]
+		dst := all[:n:n]
+		all = all[n:]
+
+		out_size, err := z.DecompressSafe(src, dst)
+		if err == nil && out_size != uint(n) {
+			err = errCorrupt
+		}
+		if err != nil {
+			return out, &BlockError{Block: i, Err: err}
+		}
+
+		out = append(out, dst)
+	}
+
+	return out, nil
+}
+
+// Probe makes a quick guess at whether src is a complete stream of z's
+// algorithm, without decompressing it.  For the lzo1x family it checks that
+// src ends with the end-of-stream marker and that its first instruction
+// could start a stream; for other algorithms only that src isn't empty.
+// It is a heuristic for skipping obviously corrupt data cheaply: a true
+// result is no guarantee, and only DecompressSafe validates src.
+func (z *Decompressor) Probe(src []byte) bool {
+
+	if z.level != Lzo1x_1 && z.level != Lzo1x_999 {
+		return len(src) > 0
+	}
+
+	// every stream ends with an M4 match of distance 0
+	n := len(src)
+	if n < 3 || src[n-3] != 0x11 || src[n-2] != 0 || src[n-1] != 0 {
+		return false
+	}
+	if n == 3 {
+		return true
+	}
+
+	switch t := int(src[0]); {
+	case t > 17:
+		// a run of t-17 literals, which must leave room for the marker
+		return 1+t-17 <= n-3
+	case t >= 16:
+		// a match, with nothing yet to refer back to
+		return false
+	case t == 0:
+		// a long literal run, its length continued in the next bytes
+		return n > 4
+	default:
+		return 1+t+3 <= n-3
+	}
+}
+
+// DecompressAppend decompresses src, which must decompress to at most
+// expectedLen bytes, and appends the result to dst, growing it as append
+// does.  It returns the extended slice; on error dst is returned without
+// the partial output.
+func (z *Decompressor) DecompressAppend(dst []byte, src []byte, expectedLen int) ([]byte, error) {
+
+	if expectedLen < 0 {
+		return dst, ErrLength
+	}
+
+	n := len(dst)
+	if cap(dst)-n < expectedLen {
+		dst = append(dst, make([]byte, expectedLen)...)
+	}
+
+	out_size, err := z.DecompressSafe(src, dst[n:n+expectedLen])
+	if err != nil {
+		return dst[:n], err
+	}
+
+	return dst[:n+int(out_size)], nil
+}
+
+// DecompressSafeLimit is like DecompressSafe, but returns ErrTooLarge if
+// src would decompress to more than maxOut bytes.  For the lzo1x family the
+// size is found by walking src without producing output, so an oversized
+// input is rejected before any decompression work; for other algorithms
+// decompression stops once maxOut bytes have been written.
+func (z *Decompressor) DecompressSafeLimit(src []byte, dst []byte, maxOut int) (uint, error) {
+
+	if z.level == Lzo1x_1 || z.level == Lzo1x_999 {
+		_, size, err := lzo1x_scan(src)
+		if err != 0 {
+			return 0, err
+		}
+		if size > maxOut {
+			return 0, ErrTooLarge
+		}
+		return z.DecompressSafe(src, dst)
+	}
+
+	if len(dst) <= maxOut {
+		return z.DecompressSafe(src, dst)
+	}
+
+	n, err := z.DecompressSafe(src, dst[:maxOut])
+	if err == ErrOutputOverrun {
+		return 0, ErrTooLarge
+	}
+	return n, err
+}
+
+// DecompressPrefix decompresses the first maxOut bytes of src, or all of it
+// if it is shorter, for previewing large blocks.  Stopping short at maxOut
+// isn't an error.  For the lzo1x family decoding stops as soon as maxOut
+// bytes have been produced, so the rest of src isn't looked at; other
+// algorithms are decompressed in full, as SmartDecompress does, and the
+// result cut short.
+func (z *Decompressor) DecompressPrefix(src []byte, maxOut int) ([]byte, error) {
+
+	if maxOut < 0 {
+		return nil, ErrLength
+	}
+
+	if z.level != Lzo1x_1 && z.level != Lzo1x_999 {
+		out, err := z.SmartDecompress(src)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxOut {
+			out = out[:maxOut]
+		}
+		return out, nil
+	}
+
+	dst := make([]byte, maxOut)
+	_, n, err := lzo1x_decode_prefix(src, dst)
+	if err != ErrOk {
+		return nil, err
+	}
+
+	return dst[:n], nil
+}
+
+// DecompressGrow decompresses b when the size of the uncompressed data isn't
+// known, retrying with a larger buffer each time the output doesn't fit.
+// If maxSize is positive and the uncompressed data would be larger,
+// ErrTooLarge is returned instead, which guards against decompression
+// bombs.
+func (z *Decompressor) DecompressGrow(b []byte, maxSize int) ([]byte, error) {
+
+	if len(b) == 0 {
+		return nil, ErrInputOverrun
+	}
+
+	for size := 4 * len(b); ; size *= 2 {
+		if maxSize > 0 && size > maxSize {
+			size = maxSize
+		}
+
+		o := make([]byte, size)
+		out_size, errno := z.decompress_safe(b, o)
+
+		err := Errno(errno)
+		if err == ErrOk {
+			return o[:out_size], nil
+		}
+		if !err.Recoverable() {
+			return nil, err
+		}
+		if maxSize > 0 && size >= maxSize {
+			return nil, ErrTooLarge
+		}
+	}
+}
+
+// maxExpansion bounds how many times its compressed size lzo1x or lzo2a
+// data can decompress to: the longest runs spell out their length in zero
+// bytes, each adding 255 to it
+const maxExpansion = 256
+
+// plausibleSize reports whether compressed bytes of data could decompress
+// to size bytes, to check a stored length before allocating for it
+func plausibleSize(compressed int, size uint64) bool {
+	return size <= uint64(compressed)*maxExpansion
+}
+
+// DefaultMaxRatio is the MaxRatio SmartDecompress uses when none is set.
+// Real data rarely comes near it.
+const DefaultMaxRatio = 1024
+
+// SmartDecompress decompresses src when the size of the uncompressed data
+// isn't known, as DecompressGrow does, with the output limited to MaxRatio
+// times the size of src as a guard against decompression bombs.  Data that
+// would expand further returns ErrTooLarge.
+func (z *Decompressor) SmartDecompress(src []byte) ([]byte, error) {
+
+	ratio := z.MaxRatio
+	if ratio <= 0 {
+		ratio = DefaultMaxRatio
+	}
+
+	if len(src) > math.MaxInt/ratio {
+		return z.DecompressGrow(src, math.MaxInt)
+	}
+	return z.DecompressGrow(src, len(src)*ratio)
+}
+
+// DecompressReuse decompresses src into scratch, using its full capacity,
+// and returns the slice of it holding the uncompressed data.  If scratch is
+// too small a larger buffer is allocated, which the caller can pass back in
+// on the next call so a loop over many blocks soon stops allocating.
+func (z *Decompressor) DecompressReuse(src []byte, scratch []byte) ([]byte, error) {
+
+	if len(src) == 0 {
+		return nil, ErrInputOverrun
+	}
+
+	o := scratch[:cap(scratch)]
+	if len(o) == 0 {
+		o = make([]byte, 4*len(src))
+	}
+
+	for {
+		out_size, errno := z.decompress_safe(src, o)
+
+		err := Errno(errno)
+		if err == ErrOk {
+			return o[:out_size], nil
+		}
+		if !err.Recoverable() {
+			return nil, err
+		}
+
+		o = make([]byte, 2*len(o))
+	}
+}
+
+// DecompressInPlace decompresses lzo1x data within a single buffer, for
+// when there isn't room for separate input and output.  The compressed data
+// must be the last compressedLen bytes of buf, and buf must be at least
+// uncompressedLen + uncompressedLen/16 + 64 + 3 bytes long, which keeps the
+// output from overtaking the input still to be read.  On success the first
+// uncompressedLen bytes of buf hold the uncompressed data.
+func (z *Decompressor) DecompressInPlace(buf []byte, compressedLen int, uncompressedLen int) error {
+
+	if z.level != Lzo1x_1 && z.level != Lzo1x_999 {
+		return errors.New("lzo: DecompressInPlace requires an lzo1x algorithm")
+	}
+
+	if compressedLen < 1 || uncompressedLen < 0 || compressedLen > len(buf) || len(buf) < lzo1x_output_size(uncompressedLen) {
+		return errors.New("lzo: buffer too small for in-place decompression")
+	}
+
+	out_size, err := z.decompress_safe(buf[len(buf)-compressedLen:], buf[:uncompressedLen])
+	if err != 0 {
+		return Errno(err)
+	}
+	if out_size != uint(uncompressedLen) {
+		return errCorrupt
+	}
+
+	return nil
+}
+
+// DecompressN decompresses the lzo1x stream at the start of b into o,
+// ignoring any data in b after the stream's end marker.  It returns the
+// number of bytes of b that were consumed and the size of the valid
+// uncompressed data.  Unlike Decompress it is safe to use on untrusted
+// input: if o is too small ErrOutputOverrun is returned.
+func (z *Decompressor) DecompressN(b []byte, o []byte) (int, uint, error) {
+
+	if z.level != Lzo1x_1 && z.level != Lzo1x_999 {
+		return 0, 0, errors.New("lzo: DecompressN requires an lzo1x algorithm")
+	}
+
+	// find where this stream ends and how large it will be
+	n, size, err := lzo1x_scan(b)
+	if err != 0 {
+		return n, 0, err
+	}
+
+	if size > len(o) {
+		return n, 0, ErrOutputOverrun
+	}
+
+	// an empty stream is just the end marker
+	if size == 0 {
+		return n, 0, nil
+	}
+
+	out_size, errno := z.decompress_safe(b[:n], o)
+	if errno != 0 {
+		return n, out_size, Errno(errno)
+	}
+
+	return n, out_size, nil
+}
+
+// DecompressAll decompresses src as a sequence of back-to-back lzo1x
+// streams, such as the output of several calls to Compress appended
+// together, and returns their concatenated uncompressed data.
+// blockHint is the expected uncompressed size of a single stream; the
+// output grows as needed when a stream turns out to be larger.  If a stream
+// fails to decompress the error is a *BlockError holding its index.
+func (z *Decompressor) DecompressAll(src []byte, blockHint int) ([]byte, error) {
+
+	if blockHint < 1 {
+		blockHint = len(src)
+	}
+
+	out := make([]byte, 0, blockHint)
+
+	for block := 0; len(src) > 0; block++ {
+		for size := blockHint; ; size *= 2 {
+			if cap(out)-len(out) < size {
+				grown := make([]byte, len(out), len(out)+size)
+				copy(grown, out)
+				out = grown
+			}
+
+			n, out_size, err := z.DecompressN(src, out[len(out):cap(out)])
+			if e, ok := err.(Errno); ok && e.Recoverable() {
+				continue
+			}
+			if err != nil {
+				return out, &BlockError{Block: block, Err: err}
+			}
+
+			out = out[:len(out)+int(out_size)]
+			src = src[n:]
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// lzo_uint_fits reports whether n can be passed to the library as a length
+func lzo_uint_fits(n int) bool {
+	return uint64(n) <= lzo_uint_max
+}
+
+// for an input of n, what is the worst-case compression we might get
+func lzo1x_output_size(n int) int {
+	return (n + n/16 + 64 + 3)
+}
+
+// lzo2a can expand incompressible input more than lzo1x
+func lzo2a_output_size(n int) int {
+	return (n + n/8 + 128 + 3)
+}
@@ -0,0 +1,645 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testInputs returns inputs from empty to a few hundred KiB, running from
+// all zeros through text to noise that doesn't compress at all
+func testInputs() [][]byte {
+
+	noise := make([]byte, 100000)
+	x := uint32(1)
+	for i := range noise {
+		x = x*1664525 + 1013904223
+		noise[i] = byte(x >> 24)
+	}
+
+	var mixed []byte
+	for i := 0; len(mixed) < 200000; i++ {
+		mixed = append(mixed, "the quick brown fox "[:i%20+1]...)
+		mixed = append(mixed, noise[i%1000:i%1000+i%7]...)
+	}
+
+	return [][]byte{
+		nil,
+		{'a'},
+		[]byte("hello"),
+		[]byte("abcdabcdabcdabcdabcdabcdabcd"),
+		bytes.Repeat([]byte{0}, 100000),
+		noise,
+		mixed,
+	}
+}
+
+func TestCompressEmpty(t *testing.T) {
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatalf("%v: %v", a, err)
+		}
+
+		for _, in := range [][]byte{nil, {}} {
+			c, err := z.Compress(in)
+			if err != nil {
+				t.Fatalf("%v: Compress(%#v): %v", a, in, err)
+			}
+
+			out := make([]byte, 16)
+			n, err := z.DecompressSafe(c, out)
+			if err != nil || n != 0 {
+				t.Errorf("%v: decompressing empty input gave %d bytes, %v", a, n, err)
+			}
+
+			// and into no room at all, which it needs none of
+			for _, out := range [][]byte{nil, {}} {
+				if n, err := z.Decompress(c, out); err != nil || n != 0 {
+					t.Errorf("%v: decompressing into %#v gave %d bytes, %v", a, out, n, err)
+				}
+				if n, err := z.DecompressSafe(c, out); err != nil || n != 0 {
+					t.Errorf("%v: safely decompressing into %#v gave %d bytes, %v", a, out, n, err)
+				}
+			}
+		}
+	}
+}
+
+func TestEstimateRatio(t *testing.T) {
+
+	inputs := testInputs()
+	text, noise := bytes.Repeat([]byte("estimate "), 100000), inputs[5]
+
+	if r := EstimateRatio(text); r > 0.1 {
+		t.Errorf("repeated text estimated at %.3f", r)
+	}
+	if r := EstimateRatio(noise); r < 1 {
+		t.Errorf("noise estimated at %.3f", r)
+	}
+	if r := EstimateRatio(nil); r != 1 {
+		t.Errorf("empty input estimated at %.3f", r)
+	}
+}
+
+func TestCompressDeterministic(t *testing.T) {
+
+	inputs := testInputs()
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, in := range inputs {
+			want, err := z.Compress(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// neither reusing the work memory nor a fresh Compressor
+			// may change the output
+			again, _ := z.Compress(in)
+			fresh, _ := NewCompressor(a)
+			other, _ := fresh.Compress(in)
+			if !bytes.Equal(again, want) || !bytes.Equal(other, want) {
+				t.Errorf("%v: %d bytes compressed differently", a, len(in))
+			}
+		}
+	}
+
+	// and every lzo1x_999 level, where the library has it
+	for level := 1; level <= 9; level++ {
+		z, err := NewCompressorLevel(level)
+		if err != nil {
+			break
+		}
+		for _, in := range inputs {
+			want, _ := z.Compress(in)
+			again, _ := z.Compress(in)
+			if !bytes.Equal(again, want) {
+				t.Errorf("level %d: %d bytes compressed differently", level, len(in))
+			}
+		}
+	}
+
+	// what liblzo writes too, as it stores short inputs as one literal run
+	z, _ := NewCompressor(Lzo1x_1)
+	c, _ := z.Compress([]byte("hello"))
+	if want := []byte{17 + 5, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0}; !bytes.Equal(c, want) {
+		t.Errorf("got %v, want %v", c, want)
+	}
+}
+
+func TestCompressorWithWorkmem(t *testing.T) {
+
+	for _, a := range SupportedAlgorithms() {
+		n := WorkMemSize(a)
+
+		// more memory than the algorithm needs is harmless
+		z, err := NewCompressorWithWorkmem(a, make([]byte, 2*n+64))
+		if err != nil {
+			t.Fatalf("%v: %v", a, err)
+		}
+		for _, in := range testInputs() {
+			c, err := z.Compress(in)
+			if err != nil {
+				t.Fatalf("%v: %v", a, err)
+			}
+			out := make([]byte, len(in))
+			if m, err := z.DecompressSafe(c, out); err != nil || !bytes.Equal(out[:m], in) {
+				t.Errorf("%v: %d bytes: round trip failed: %v", a, len(in), err)
+			}
+		}
+
+		if n > 0 {
+			if _, err := NewCompressorWithWorkmem(a, make([]byte, n-1)); err == nil {
+				t.Errorf("%v: accepted %d bytes of work memory, less than %d", a, n-1, n)
+			}
+		}
+	}
+}
+
+// message is a 4 KiB message, the size RPC payloads often are
+func message() []byte {
+	return testInputs()[6][:4096]
+}
+
+// The Alloc benchmarks pay for fresh buffers on every call: a new
+// Compressor, whose work memory is allocated on its first Compress, or a
+// new output buffer.  The Reuse ones keep them from call to call.
+
+func BenchmarkCompressAlloc(b *testing.B) {
+
+	msg := message()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z, _ := NewCompressor(Lzo1x_1)
+		z.Compress(msg)
+	}
+}
+
+func BenchmarkCompressReuse(b *testing.B) {
+
+	z, _ := NewCompressor(Lzo1x_1)
+	msg := message()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.Compress(msg)
+	}
+}
+
+func BenchmarkDecompressAlloc(b *testing.B) {
+
+	z, _ := NewCompressor(Lzo1x_1)
+	msg := message()
+	c, _ := z.Compress(msg)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.DecompressSafe(c, make([]byte, len(msg)))
+	}
+}
+
+func BenchmarkDecompressReuse(b *testing.B) {
+
+	z, _ := NewCompressor(Lzo1x_1)
+	msg := message()
+	c, _ := z.Compress(msg)
+
+	var scratch []byte
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scratch, _ = z.DecompressReuse(c, scratch)
+	}
+}
+
+func TestDecompressSafeBoundary(t *testing.T) {
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, in := range testInputs()[1:] {
+			c, err := z.Compress(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, size := range []int{len(in), len(in) + 1} {
+				out := make([]byte, size)
+				n, err := z.DecompressSafe(c, out)
+				if err != nil || int(n) != len(in) || !bytes.Equal(out[:n], in) {
+					t.Errorf("%v: %d bytes into %d: got %d, %v", a, len(in), size, n, err)
+				}
+			}
+
+			// the fast decompressor trusts the size, so only give it
+			// the exact one
+			out := make([]byte, len(in))
+			if n, err := z.Decompress(c, out); err != nil || !bytes.Equal(out[:n], in) {
+				t.Errorf("%v: %d bytes: got %d, %v", a, len(in), n, err)
+			}
+
+			// one byte short must fail, leaving only valid output
+			out = make([]byte, len(in)-1)
+			n, err := z.DecompressSafe(c, out)
+			if err != ErrOutputOverrun || !bytes.Equal(out[:n], in[:n]) {
+				t.Errorf("%v: %d bytes into %d: got %d, %v", a, len(in), len(out), n, err)
+			}
+		}
+	}
+}
+
+func TestDecompressAllocs(t *testing.T) {
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := message()
+		c, _ := z.Compress(in)
+		out := make([]byte, len(in))
+
+		// the sizes come back by value, so nothing escapes to the heap
+		n := testing.AllocsPerRun(100, func() {
+			if m, err := z.Decompress(c, out); err != nil || int(m) != len(in) {
+				t.Fatal(m, err)
+			}
+			if m, err := z.DecompressSafe(c, out); err != nil || int(m) != len(in) {
+				t.Fatal(m, err)
+			}
+		})
+		if n != 0 {
+			t.Errorf("%v: %v allocations per decompression", a, n)
+		}
+	}
+}
+
+func TestCompressManyAllocs(t *testing.T) {
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srcs [][]byte
+	for _, in := range testInputs() {
+		srcs = append(srcs, in[:len(in)/4])
+	}
+
+	cs, err := z.CompressMany(srcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cs {
+		out := make([]byte, len(srcs[i]))
+		if n, err := z.DecompressSafe(c, out); err != nil || !bytes.Equal(out[:n], srcs[i]) {
+			t.Errorf("record %d: got %d, %v", i, n, err)
+		}
+	}
+
+	// beyond what compressing each record costs, one buffer for the
+	// results and one for the slice of them
+	scratch := make([]byte, z.output_size(len(srcs[len(srcs)-1])))
+	each := testing.AllocsPerRun(10, func() { z.compressTo(srcs[len(srcs)-1], scratch) })
+	n := testing.AllocsPerRun(10, func() { z.CompressMany(srcs) })
+	if want := each*float64(len(srcs)) + 2; n > want {
+		t.Errorf("%v allocations per batch, want at most %v", n, want)
+	}
+}
+
+func TestSmartDecompressMaxRatio(t *testing.T) {
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testInputs()[4]
+	c, _ := z.Compress(in)
+
+	out, err := z.SmartDecompress(c)
+	if err != nil || !bytes.Equal(out, in) {
+		t.Fatalf("with the default ratio: got %d bytes, %v", len(out), err)
+	}
+
+	// zeros expand far more than 10 times
+	z.MaxRatio = 10
+	if _, err := z.SmartDecompress(c); err != ErrTooLarge {
+		t.Errorf("with a ratio of 10: got %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestCompressVerified(t *testing.T) {
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := testInputs()[6]
+	c, err := z.CompressVerified(in)
+	if want, _ := z.Compress(in); err != nil || !bytes.Equal(c, want) {
+		t.Fatalf("got %d bytes, %v", len(c), err)
+	}
+
+	// a compressor that gets the data wrong must be caught
+	compress := z.compress
+	z.compress = func(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+		r := compress(b, out, out_size, wrkmem)
+		out[*out_size/2] ^= 1
+		return r
+	}
+	if _, err := z.CompressVerified(in); err == nil {
+		t.Error("corrupt output was verified")
+	}
+}
+
+func BenchmarkCompressVerified(b *testing.B) {
+
+	z, _ := NewCompressor(Lzo1x_1)
+	in := testInputs()[6]
+
+	b.Run("plain", func(b *testing.B) {
+		b.SetBytes(int64(len(in)))
+		for i := 0; i < b.N; i++ {
+			z.Compress(in)
+		}
+	})
+	b.Run("verified", func(b *testing.B) {
+		b.SetBytes(int64(len(in)))
+		for i := 0; i < b.N; i++ {
+			z.CompressVerified(in)
+		}
+	})
+}
+
+// TestMalformedCorpus decompresses the streams in testdata/malformed, each
+// broken in its own way or too big for the output, and expects an error
+// from every one.  FuzzDecompress starts from them too.
+func TestMalformedCorpus(t *testing.T) {
+
+	files, err := filepath.Glob(filepath.Join("testdata", "malformed", "*"))
+	if err != nil || len(files) == 0 {
+		t.Fatal("no corpus:", err)
+	}
+
+	z, err := NewDecompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		in, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dst := make([]byte, 1<<16)
+		if n, err := z.DecompressSafe(in, dst); err == nil {
+			t.Errorf("%s: decompressed %d bytes without error", filepath.Base(file), n)
+		}
+	}
+}
+
+func TestRoundTripMatrix(t *testing.T) {
+
+	inputs := testInputs()
+	noise, mixed := inputs[5], inputs[6]
+
+	// data of any size, from a repeating source
+	fill := func(src []byte, n int) []byte {
+		b := make([]byte, 0, n)
+		for len(b) < n {
+			b = append(b, src[:min(len(src), n-len(b))]...)
+		}
+		return b
+	}
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := z.wrkmem_len
+		sizes := []int{0, 1, 2, 13, 64 * 1024, 3 << 20}
+		if w > 1 {
+			sizes = append(sizes, w-1, w, w+1)
+		}
+
+		for _, size := range sizes {
+			for name, src := range map[string][]byte{"zeros": {0}, "noise": noise, "mixed": mixed} {
+				in := fill(src, size)
+				c, err := z.Compress(in)
+				if err != nil {
+					t.Fatalf("%v/%s/%d: %v", a, name, size, err)
+				}
+				out := make([]byte, size)
+				n, err := z.DecompressSafe(c, out)
+				if err != nil || int(n) != size || !bytes.Equal(out, in) {
+					t.Errorf("%v/%s/%d: round trip failed: %d, %v", a, name, size, n, err)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkDecompressSafe compares the library's two decompressors; without
+// cgo both are the same bounds checked Go code
+func BenchmarkDecompressSafe(b *testing.B) {
+
+	for _, a := range SupportedAlgorithms() {
+		z, _ := NewCompressor(a)
+		in := testInputs()[6]
+		c, _ := z.Compress(in)
+		out := make([]byte, len(in))
+
+		b.Run(a.String()+"/fast", func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				z.Decompress(c, out)
+			}
+		})
+		b.Run(a.String()+"/safe", func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				z.DecompressSafe(c, out)
+			}
+		})
+	}
+}
+
+// BenchmarkLevels reports the speed and compression ratio of each
+// algorithm and lzo1x_999 level, as a table for choosing between them
+func BenchmarkLevels(b *testing.B) {
+
+	in := testInputs()[6]
+
+	type level struct {
+		name string
+		new  func() (*Compressor, error)
+	}
+	levels := []level{
+		{"lzo1x_1", func() (*Compressor, error) { return NewCompressor(Lzo1x_1) }},
+		{"lzo2a_999", func() (*Compressor, error) { return NewCompressor(Lzo2a_999) }},
+	}
+	for l := 1; l <= 9; l++ {
+		l := l
+		levels = append(levels, level{fmt.Sprintf("lzo1x_999/%d", l), func() (*Compressor, error) { return NewCompressorLevel(l) }})
+	}
+
+	for _, l := range levels {
+		b.Run(l.name, func(b *testing.B) {
+			z, err := l.new()
+			if err != nil {
+				b.Skip(err)
+			}
+
+			var c []byte
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				c, _ = z.Compress(in)
+			}
+			b.ReportMetric(float64(len(c))/float64(len(in)), "ratio")
+		})
+	}
+}
+
+func TestCompressOptimized(t *testing.T) {
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a == Lzo2a_999 {
+			if _, err := z.CompressOptimized([]byte("lzo2a")); err == nil {
+				t.Error("lzo2a data was optimized")
+			}
+			continue
+		}
+
+		for _, in := range testInputs() {
+			c, err := z.CompressOptimized(in)
+			if err != nil {
+				t.Fatalf("%v: %d bytes: %v", a, len(in), err)
+			}
+
+			// optimizing rewrites the data in place, never growing it
+			if plain, _ := z.Compress(in); len(c) != len(plain) {
+				t.Errorf("%v: %d bytes: optimized to %d bytes, not %d", a, len(in), len(c), len(plain))
+			}
+
+			out := make([]byte, len(in))
+			if n, err := z.DecompressSafe(c, out); err != nil || !bytes.Equal(out[:n], in) {
+				t.Errorf("%v: %d bytes: round trip failed: %v", a, len(in), err)
+			}
+		}
+	}
+}
+
+func TestSmallInputs(t *testing.T) {
+
+	src := testInputs()[6]
+
+	for _, a := range SupportedAlgorithms() {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for size := 1; size <= 16; size++ {
+			in := src[:size]
+			c, err := z.Compress(in)
+			if err != nil {
+				t.Fatalf("%v: %d bytes: %v", a, size, err)
+			}
+			if len(c) <= size {
+				t.Errorf("%v: %d bytes compressed to %d, but inputs this small can only grow", a, size, len(c))
+			}
+			if len(c) > z.output_size(size) {
+				t.Errorf("%v: %d bytes compressed to %d, more than the worst case", a, size, len(c))
+			}
+
+			out := make([]byte, size)
+			if n, err := z.DecompressSafe(c, out); err != nil || !bytes.Equal(out[:n], in) {
+				t.Errorf("%v: %d bytes: round trip failed: %v", a, size, err)
+			}
+		}
+	}
+}
+
+func TestWriterStoresSmallBlocks(t *testing.T) {
+
+	for size := 1; size <= 16; size++ {
+		in := testInputs()[6][:size]
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, Lzo1x_1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(in)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// a block that would only grow goes out as it is, its compressed
+		// size the same as its size
+		var block []byte
+		block = binary.BigEndian.AppendUint32(block, uint32(size))
+		block = binary.BigEndian.AppendUint32(block, uint32(size))
+		block = append(block, in...)
+		if !bytes.Contains(buf.Bytes(), block) {
+			t.Errorf("%d bytes weren't stored uncompressed", size)
+		}
+	}
+}
+
+// BenchmarkSmallInputs shows the fixed cost of a call, which dominates
+// for inputs too small to compress
+func BenchmarkSmallInputs(b *testing.B) {
+
+	z, _ := NewCompressor(Lzo1x_1)
+	src := testInputs()[6]
+
+	for _, size := range []int{1, 4, 8, 16} {
+		in := src[:size]
+		c, _ := z.Compress(in)
+		out := make([]byte, size)
+
+		b.Run(fmt.Sprintf("compress/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				z.Compress(in)
+			}
+			b.ReportMetric(float64(len(c))/float64(size), "ratio")
+		})
+		b.Run(fmt.Sprintf("decompress/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				z.DecompressSafe(c, out)
+			}
+		})
+	}
+}
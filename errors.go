@@ -0,0 +1,114 @@
+package lzo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errno is an lzo error
+type Errno int
+
+var errText = map[Errno]string{
+	0:  "ok",
+	-1: "error",
+	-2: "[unused] out of memory",
+	-3: "[unused] not compressible",
+	-4: "input overrun",
+	-5: "output overrun",
+	-6: "lookbehind overrun",
+	-7: "eof not found",
+	-8: "input not consumed",
+	-9: "[unused] not yet implemented",
+}
+
+func (e Errno) Error() string {
+
+	s := errText[e]
+	if s == "" {
+		return fmt.Sprintf("errno %d", int(e))
+	}
+	return s
+}
+
+var (
+	ErrOk                = Errno(0)
+	ErrError             = Errno(-1)
+	ErrOutOfMemory       = Errno(-2) /* [not used right now] */
+	ErrNotCompressible   = Errno(-3) /* [not used right now] */
+	ErrInputOverrun      = Errno(-4)
+	ErrOutputOverrun     = Errno(-5)
+	ErrLookbehindOverrun = Errno(-6)
+	ErrEofNotFound       = Errno(-7)
+	ErrInputNotConsumed  = Errno(-8)
+	ErrNotYetImplemented = Errno(-9) /* [not used right now] */
+)
+
+// Recoverable reports whether the operation that failed with e may succeed
+// if retried with a larger output buffer, rather than the input being
+// corrupt.
+func (e Errno) Recoverable() bool {
+	return e == ErrOutputOverrun
+}
+
+// ErrLength is returned for a buffer too long to pass to the LZO library,
+// whose lengths are lzo_uints and may be only 32 bits wide
+var ErrLength = errors.New("lzo: buffer too long for lzo_uint")
+
+// ErrTooLarge is returned when decompressed data would exceed a configured
+// size limit
+var ErrTooLarge = errors.New("lzo: decompressed data exceeds size limit")
+
+// UnsupportedAlgorithmError is returned for an algorithm that isn't
+// available in this build
+type UnsupportedAlgorithmError LzoAlgorithm
+
+func (e UnsupportedAlgorithmError) Error() string {
+	return "lzo: algorithm " + LzoAlgorithm(e).String() + " not supported by this build"
+}
+
+// BlockError records which block of a multi-block input failed to
+// decompress
+type BlockError struct {
+	Block int
+	Err   error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("lzo: block %d: %v", e.Block, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// ErrChecksum matches any ChecksumError with errors.Is, for callers that
+// don't need the details.
+var ErrChecksum = errors.New("lzo: checksum error")
+
+// ChecksumError is returned when decompressed data doesn't match the
+// checksum stored alongside it.  Kind names the checksum, such as "adler32".
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+	Kind     string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("lzo: %s checksum error: expected %08x, got %08x", e.Kind, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksum
+}
+
+// HeaderError is returned when a stream header is malformed or uses a
+// feature this package doesn't support.  Field names the offending part of
+// the header.
+type HeaderError struct {
+	Field  string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return "lzo: invalid header " + e.Field + ": " + e.Reason
+}
@@ -0,0 +1,57 @@
+package lzo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+
+	tests := []struct {
+		err    error
+		target error
+	}{
+		{&BlockError{Block: 2, Err: ErrOutputOverrun}, ErrOutputOverrun},
+		{&ChecksumError{Kind: "adler32"}, ErrChecksum},
+		{&BlockError{Block: 1, Err: &ChecksumError{Kind: "crc32"}}, ErrChecksum},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.target) {
+			t.Errorf("%v doesn't match %v", tt.err, tt.target)
+		}
+	}
+
+	if errors.Is(&BlockError{Err: ErrInputOverrun}, ErrOutputOverrun) {
+		t.Error("one Errno matched another")
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+
+	var ce *ChecksumError
+	err := error(&BlockError{Block: 1, Err: &ChecksumError{Kind: "adler32"}})
+	if !errors.As(err, &ce) || ce.Kind != "adler32" {
+		t.Errorf("%v: no ChecksumError found", err)
+	}
+
+	var ue UnsupportedAlgorithmError
+	if _, err := NewCompressor(LzoAlgorithm(99)); !errors.As(err, &ue) || LzoAlgorithm(ue) != 99 {
+		t.Errorf("got %v, want an UnsupportedAlgorithmError", err)
+	}
+}
+
+func TestDecodeChecksum(t *testing.T) {
+
+	blob, err := Encode([]byte("checksum me, checksum me"), Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+
+	_, err = Decode(blob)
+	var ce *ChecksumError
+	if !errors.Is(err, ErrChecksum) || !errors.As(err, &ce) || ce.Kind != "adler32" {
+		t.Errorf("got %v, want an adler32 ChecksumError", err)
+	}
+}
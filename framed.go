@@ -0,0 +1,333 @@
+package lzo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+)
+
+// AppendFramed appends compressed, typically the output of Compress, to dst
+// preceded by its length as a big-endian uint32, and returns the extended
+// slice.  A series of framed blocks can be walked with IterateFramed.
+func AppendFramed(dst []byte, compressed []byte) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(compressed)))
+	dst = append(dst, hdr[:]...)
+	return append(dst, compressed...)
+}
+
+// IterateFramed calls fn with each block appended to src by AppendFramed, in
+// order, stopping at the first error fn returns.  A truncated block returns
+// io.ErrUnexpectedEOF.
+func IterateFramed(src []byte, fn func(block []byte) error) error {
+
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return io.ErrUnexpectedEOF
+		}
+
+		n := binary.BigEndian.Uint32(src)
+		src = src[4:]
+
+		if uint64(n) > uint64(len(src)) {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err := fn(src[:n]); err != nil {
+			return err
+		}
+		src = src[n:]
+	}
+
+	return nil
+}
+
+// CompressWithLength compresses src and returns it preceded by its
+// uncompressed length as a big-endian uint32, so that DecompressWithLength
+// can size its output exactly.
+func (z *Compressor) CompressWithLength(src []byte) ([]byte, error) {
+
+	if uint64(len(src)) > 0xffffffff {
+		return nil, ErrLength
+	}
+
+	c, err := z.Compress(src)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 4+len(c))
+	binary.BigEndian.PutUint32(out, uint32(len(src)))
+	copy(out[4:], c)
+
+	return out, nil
+}
+
+// DecompressWithLength decompresses the output of CompressWithLength,
+// allocating exactly the recorded uncompressed length.  Data that doesn't
+// decompress to that length is an error.
+func (z *Decompressor) DecompressWithLength(src []byte) ([]byte, error) {
+
+	if len(src) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	n := binary.BigEndian.Uint32(src)
+	src = src[4:]
+
+	// check the length before trusting it with an allocation
+	if !plausibleSize(len(src), uint64(n)) {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, n)
+	out_size, err := z.DecompressSafe(src, out)
+	if err != nil {
+		return nil, err
+	}
+	if out_size != uint(n) {
+		return nil, errCorrupt
+	}
+
+	return out, nil
+}
+
+// Encode and Decode use a self-describing format for a single buffer:
+//
+//	magic      1 byte, 0xe9
+//	version    1 byte, encodeVersion
+//	algorithm  1 byte, an LzoAlgorithm
+//	length     uncompressed length, big-endian uint32
+//	data       the output of Compress
+//	checksum   Adler32 of the uncompressed data, big-endian uint32
+//
+// A later version may change what follows the version byte.
+
+const (
+	encodeMagic   = 0xe9
+	encodeVersion = 1
+)
+
+// Encode compresses src with algorithm into a standalone blob that Decode
+// can decompress with no other information.
+func Encode(src []byte, algorithm LzoAlgorithm) ([]byte, error) {
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(z, src)
+}
+
+// encode is Encode with an existing Compressor
+func encode(z *Compressor, src []byte) ([]byte, error) {
+
+	if uint64(len(src)) > 0xffffffff {
+		return nil, ErrLength
+	}
+
+	c, err := z.Compress(src)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 7+len(c)+4)
+	out[0] = encodeMagic
+	out[1] = encodeVersion
+	out[2] = byte(z.level)
+	binary.BigEndian.PutUint32(out[3:], uint32(len(src)))
+	copy(out[7:], c)
+	binary.BigEndian.PutUint32(out[7+len(c):], adler32.Checksum(src))
+
+	return out, nil
+}
+
+// Decode decompresses a blob made by Encode, checking its checksum.
+func Decode(blob []byte) ([]byte, error) {
+
+	if len(blob) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if blob[0] != encodeMagic {
+		return nil, &HeaderError{Field: "magic", Reason: "not an encoded blob"}
+	}
+	if blob[1] != encodeVersion {
+		return nil, &HeaderError{Field: "version", Reason: fmt.Sprintf("unknown version %d", blob[1])}
+	}
+	if len(blob) < 7+4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	z, err := NewDecompressor(LzoAlgorithm(blob[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(blob[3:])
+	c := blob[7 : len(blob)-4]
+
+	if !plausibleSize(len(c), uint64(n)) {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, n)
+	out_size, err := z.DecompressSafe(c, out)
+	if err != nil {
+		return nil, err
+	}
+	if out_size != uint(n) {
+		return nil, errCorrupt
+	}
+
+	expected := binary.BigEndian.Uint32(blob[len(blob)-4:])
+	if actual := adler32.Checksum(out); actual != expected {
+		return nil, &ChecksumError{Expected: expected, Actual: actual, Kind: "adler32"}
+	}
+
+	return out, nil
+}
+
+// An EncodeWriter writes each message passed to Write as a separate Encode
+// blob, framed as AppendFramed does so that an EncodeReader can find where
+// each ends.  Unlike the Writer, it keeps the message boundaries, for
+// message-oriented transports.
+type EncodeWriter struct {
+	w io.Writer
+	z *Compressor
+}
+
+// NewEncodeWriter returns an EncodeWriter that writes to w, compressing
+// with algorithm.
+func NewEncodeWriter(w io.Writer, algorithm LzoAlgorithm) (*EncodeWriter, error) {
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncodeWriter{w: w, z: z}, nil
+}
+
+// Write writes p as one message.  An empty p writes nothing.
+func (w *EncodeWriter) Write(p []byte) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := writeEncoded(w.w, w.z, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// writeEncoded writes msg to w as a framed Encode blob
+func writeEncoded(w io.Writer, z *Compressor, msg []byte) error {
+
+	blob, err := encode(z, msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(AppendFramed(nil, blob))
+	return err
+}
+
+// An EncodeReader reads back the messages written by an EncodeWriter.
+type EncodeReader struct {
+	// MaxMessageSize is the largest message Next accepts; a message
+	// claiming to be larger returns ErrTooLarge without being read, so a
+	// corrupt or malicious length can't force a huge allocation.  0 or
+	// less means DefaultMaxFrameSize.
+	MaxMessageSize int
+
+	r io.Reader
+}
+
+// NewEncodeReader returns an EncodeReader that reads from r, with a
+// MaxMessageSize of DefaultMaxFrameSize.
+func NewEncodeReader(r io.Reader) *EncodeReader {
+	return &EncodeReader{MaxMessageSize: DefaultMaxFrameSize, r: r}
+}
+
+// Next returns the next message, decoded and checked as Decode does.  It
+// returns io.EOF once r ends between messages, and io.ErrUnexpectedEOF if
+// it ends partway through one.
+func (r *EncodeReader) Next() ([]byte, error) {
+	return readEncoded(r.r, r.MaxMessageSize)
+}
+
+// readEncoded reads a framed Encode blob from r and decodes it.  A blob
+// that decompresses to more than max bytes, or couldn't be compressed from
+// max bytes, returns ErrTooLarge before anything is allocated for it.  A
+// max of 0 or less means DefaultMaxFrameSize; there is no unlimited
+// setting.
+func readEncoded(r io.Reader, max int) ([]byte, error) {
+
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := int64(binary.BigEndian.Uint32(hdr[:]))
+
+	// lzo2a has the largest worst case of the algorithms
+	if n > int64(7+lzo2a_output_size(max)+4) {
+		return nil, ErrTooLarge
+	}
+
+	// read the blob as it arrives rather than trusting its length up
+	// front, so a corrupt length can't force a huge allocation
+	blob, err := io.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(blob)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if len(blob) >= 7 && int64(binary.BigEndian.Uint32(blob[3:])) > int64(max) {
+		return nil, ErrTooLarge
+	}
+
+	return Decode(blob)
+}
+
+// A FrameEncoder is an EncodeWriter under the name RPC codecs expect, for
+// writing length-delimited frames with WriteFrame.
+type FrameEncoder = EncodeWriter
+
+// NewFrameEncoder is NewEncodeWriter.
+func NewFrameEncoder(w io.Writer, algorithm LzoAlgorithm) (*FrameEncoder, error) {
+	return NewEncodeWriter(w, algorithm)
+}
+
+// WriteFrame compresses msg and writes it as one message.  Unlike Write,
+// an empty msg is written as an empty message.
+func (w *EncodeWriter) WriteFrame(msg []byte) error {
+	return writeEncoded(w.w, w.z, msg)
+}
+
+// DefaultMaxFrameSize is the MaxMessageSize of a new EncodeReader.
+const DefaultMaxFrameSize = 4 << 20
+
+// A FrameDecoder is an EncodeReader under the name RPC codecs expect, for
+// reading the frames of a FrameEncoder with ReadFrame.
+type FrameDecoder = EncodeReader
+
+// NewFrameDecoder is NewEncodeReader.
+func NewFrameDecoder(r io.Reader) *FrameDecoder {
+	return NewEncodeReader(r)
+}
+
+// ReadFrame is Next.
+func (r *EncodeReader) ReadFrame() ([]byte, error) {
+	return r.Next()
+}
@@ -0,0 +1,78 @@
+package lzo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecompressWithLength(t *testing.T) {
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range testInputs() {
+		c, err := z.CompressWithLength(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := z.DecompressWithLength(c)
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("%d bytes: round trip failed: %v", len(in), err)
+		}
+	}
+
+	// a few bytes claiming 4 GiB mustn't be allocated for
+	bomb := []byte{0xff, 0xff, 0xff, 0xff, 0x11, 0, 0}
+	if _, err := z.DecompressWithLength(bomb); err != errCorrupt {
+		t.Errorf("got %v, want %v", err, errCorrupt)
+	}
+}
+
+func TestDecode(t *testing.T) {
+
+	for _, in := range testInputs() {
+		blob, err := Encode(in, Lzo1x_1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Decode(blob)
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("%d bytes: round trip failed: %v", len(in), err)
+		}
+	}
+
+	// the 14 byte blob that once allocated 4 GiB
+	bomb := []byte{encodeMagic, encodeVersion, byte(Lzo1x_1), 0xff, 0xff, 0xff, 0xff, 0x11, 0, 0, 0, 0, 0, 1}
+	if _, err := Decode(bomb); err != errCorrupt {
+		t.Errorf("got %v, want %v", err, errCorrupt)
+	}
+}
+
+func TestEncodeReaderLimit(t *testing.T) {
+
+	var buf bytes.Buffer
+	w, err := NewEncodeWriter(&buf, Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, DefaultMaxFrameSize+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0 is no way around the limit
+	for _, max := range []int{DefaultMaxFrameSize, 0} {
+		r := NewEncodeReader(bytes.NewReader(buf.Bytes()))
+		r.MaxMessageSize = max
+		if _, err := r.Next(); err != ErrTooLarge {
+			t.Errorf("MaxMessageSize %d: got %v, want %v", max, err, ErrTooLarge)
+		}
+	}
+
+	r := NewEncodeReader(bytes.NewReader(buf.Bytes()))
+	r.MaxMessageSize = DefaultMaxFrameSize + 1
+	if msg, err := r.Next(); err != nil || len(msg) != DefaultMaxFrameSize+1 {
+		t.Errorf("got %d bytes, %v", len(msg), err)
+	}
+}
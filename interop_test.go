@@ -0,0 +1,59 @@
+//go:build cgo
+
+package lzo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// With cgo the pure Go codec is still compiled in, so it can be checked
+// against the library in both directions.
+
+func TestLibraryToGo(t *testing.T) {
+
+	for _, a := range []LzoAlgorithm{Lzo1x_1, Lzo1x_999} {
+		z, err := NewCompressor(a)
+		if err != nil {
+			t.Logf("%v: %v", a, err)
+			continue
+		}
+
+		for _, in := range testInputs() {
+			c, err := z.Compress(in)
+			if err != nil {
+				t.Fatalf("%v: %v", a, err)
+			}
+
+			out := make([]byte, len(in))
+			ip, op, errno := lzo1x_decode(c, out)
+			if errno != ErrOk || ip != len(c) || !bytes.Equal(out[:op], in) {
+				t.Errorf("%v: %d bytes: Go decoder got %d, %d, %v", a, len(in), ip, op, errno)
+			}
+		}
+	}
+}
+
+func TestGoToLibrary(t *testing.T) {
+
+	d, err := NewDecompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range testInputs() {
+		c := lzo1x_1_encode(nil, in)
+
+		out := make([]byte, len(in))
+		n, err := d.DecompressSafe(c, out)
+		if err != nil || !bytes.Equal(out[:n], in) {
+			t.Errorf("%d bytes: library decoder got %d, %v", len(in), n, err)
+		}
+
+		// the fast decompressor mustn't be tripped up either
+		n, err = d.Decompress(c, out)
+		if err != nil || !bytes.Equal(out[:n], in) {
+			t.Errorf("%d bytes: fast library decoder got %d, %v", len(in), n, err)
+		}
+	}
+}
@@ -1,4 +1,10 @@
+//go:build cgo
+
 // Package lzo provides access to the LZO library, version 2.05
+//
+// When cgo is disabled, for example when cross compiling or building with
+// CGO_ENABLED=0, pure Go implementations of the lzo1x decompressor and the
+// lzo1x_1 compressor are used in place of the library.
 /*
 
 License: GPLv3 or later
@@ -8,10 +14,31 @@ Copyright (C) 2011 Damian Gryski <damian@gryski.com>
 package lzo
 
 /*
-#cgo LDFLAGS: -llzo2 -L/opt/local/lib/
-
+// liblzo2 is located with pkg-config.  Build with -tags lzo_nopkgconfig to
+// link it directly instead, passing any extra search path in CGO_LDFLAGS.
+#cgo !lzo_nopkgconfig pkg-config: lzo2
+#cgo lzo_nopkgconfig LDFLAGS: -llzo2
+#cgo darwin,lzo_nopkgconfig LDFLAGS: -L/opt/local/lib
+#cgo lzo_nopkgconfig CFLAGS: -DMY_LZO_SUBDIR
+
+// lzo1x_999, with the lzo1x dictionary decompressor and optimizer, and
+// lzo2a can be left out of a liblzo2 build.  Against such a library, build
+// with -tags lzo_nolzo1x999 or -tags lzo_nolzo2a so that nothing refers to
+// the missing functions; the algorithms are then reported as unsupported.
+#cgo lzo_nolzo1x999 CFLAGS: -DMY_NO_LZO1X_999
+#cgo lzo_nolzo2a CFLAGS: -DMY_NO_LZO2A
+
+// lzo2.pc puts the lzo directory itself on the include path; otherwise
+// the headers are found under lzo/ in a standard include directory.
+#ifdef MY_LZO_SUBDIR
 #include <lzo/lzoconf.h>
 #include <lzo/lzo1x.h>
+#include <lzo/lzo2a.h>
+#else
+#include <lzoconf.h>
+#include <lzo1x.h>
+#include <lzo2a.h>
+#endif
 
 // lzo_init is a macro -- we need a function so we can call it from Go
 static int my_lzo_init(void) { return lzo_init(); }
@@ -20,6 +47,113 @@ static int my_lzo_init(void) { return lzo_init(); }
 // again, a macro so we need to be able to call it from Go
 static int lzo1x_1_mem_compress() { return LZO1X_1_MEM_COMPRESS; }
 static int lzo1x_999_mem_compress() { return LZO1X_999_MEM_COMPRESS; }
+static int lzo2a_999_mem_compress() { return LZO2A_999_MEM_COMPRESS; }
+
+// the version of the headers we were built against, also macros
+static unsigned my_lzo_version() { return LZO_VERSION; }
+static const char *my_lzo_version_string() { return LZO_VERSION_STRING; }
+static const char *my_lzo_version_date() { return LZO_VERSION_DATE; }
+
+// the decompressors return the output length through a pointer, which
+// would make Go allocate it on the heap for every call; these return it by
+// value instead
+typedef struct { int err; lzo_uint len; } my_result;
+
+static my_result my_lzo1x_decompress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo1x_decompress(src, src_len, dst, &r.len, NULL);
+	return r;
+}
+
+static my_result my_lzo1x_decompress_safe(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo1x_decompress_safe(src, src_len, dst, &r.len, NULL);
+	return r;
+}
+
+// the optional functions are only called through these, which fail with
+// LZO_E_NOT_YET_IMPLEMENTED when the build leaves them out
+#ifndef MY_NO_LZO1X_999
+enum { my_have_lzo1x_999 = 1 };
+
+static int my_lzo1x_999_compress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem) {
+	return lzo1x_999_compress(src, src_len, dst, dst_len, wrkmem);
+}
+
+static int my_lzo1x_999_compress_level(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem, const unsigned char *dict, lzo_uint dict_len, int level) {
+	return lzo1x_999_compress_level(src, src_len, dst, dst_len, wrkmem, dict, dict_len, NULL, level);
+}
+
+static my_result my_lzo1x_decompress_dict_safe(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len, const unsigned char *dict, lzo_uint dict_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo1x_decompress_dict_safe(src, src_len, dst, &r.len, NULL, dict, dict_len);
+	return r;
+}
+
+// lzo1x_optimize rewrites the compressed data in place, decompressing it
+// into dst as it goes
+static my_result my_lzo1x_optimize(unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo1x_optimize(src, src_len, dst, &r.len, NULL);
+	return r;
+}
+#else
+enum { my_have_lzo1x_999 = 0 };
+
+static int my_lzo1x_999_compress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem) {
+	return LZO_E_NOT_YET_IMPLEMENTED;
+}
+
+static int my_lzo1x_999_compress_level(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem, const unsigned char *dict, lzo_uint dict_len, int level) {
+	return LZO_E_NOT_YET_IMPLEMENTED;
+}
+
+static my_result my_lzo1x_decompress_dict_safe(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len, const unsigned char *dict, lzo_uint dict_len) {
+	my_result r = { LZO_E_NOT_YET_IMPLEMENTED, 0 };
+	return r;
+}
+
+static my_result my_lzo1x_optimize(unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { LZO_E_NOT_YET_IMPLEMENTED, 0 };
+	return r;
+}
+#endif
+
+#ifndef MY_NO_LZO2A
+enum { my_have_lzo2a = 1 };
+
+static int my_lzo2a_999_compress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem) {
+	return lzo2a_999_compress(src, src_len, dst, dst_len, wrkmem);
+}
+
+static my_result my_lzo2a_decompress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo2a_decompress(src, src_len, dst, &r.len, NULL);
+	return r;
+}
+
+static my_result my_lzo2a_decompress_safe(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { 0, dst_len };
+	r.err = lzo2a_decompress_safe(src, src_len, dst, &r.len, NULL);
+	return r;
+}
+#else
+enum { my_have_lzo2a = 0 };
+
+static int my_lzo2a_999_compress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint *dst_len, void *wrkmem) {
+	return LZO_E_NOT_YET_IMPLEMENTED;
+}
+
+static my_result my_lzo2a_decompress(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { LZO_E_NOT_YET_IMPLEMENTED, 0 };
+	return r;
+}
+
+static my_result my_lzo2a_decompress_safe(const unsigned char *src, lzo_uint src_len, unsigned char *dst, lzo_uint dst_len) {
+	my_result r = { LZO_E_NOT_YET_IMPLEMENTED, 0 };
+	return r;
+}
+#endif
 
 */
 import "C"
@@ -29,81 +163,125 @@ import (
 	"unsafe"
 )
 
-// Errno is an lzo error
-type Errno int
+// lzo_uint_max is the largest length the library can take, as lzo_uint may
+// be narrower than a Go int
+const lzo_uint_max = ^uint64(0) >> (64 - 8*C.sizeof_lzo_uint)
+
+// initErr records lzo_init failing, usually because the library headers
+// and the linked library don't match
+var initErr error
 
-var errText = map[Errno]string{
-	0:  "ok",
-	-1: "error",
-	-2: "[unused] out of memory",
-	-3: "[unused] not compressible",
-	-4: "input overrun",
-	-5: "output overrun",
-	-6: "lookbehind overrun",
-	-7: "eof not found",
-	-8: "input not consumed",
-	-9: "[unused] not yet implemented",
+func init() {
+	if err := C.my_lzo_init(); err != 0 {
+		initErr = fmt.Errorf("lzo: library initialization failed: %w", Errno(err))
+	}
 }
 
-func (e Errno) Error() string {
+// probeAlgorithms checks which algorithms work with the library we were
+// linked against, since not every liblzo2 build provides every algorithm
+func probeAlgorithms() map[LzoAlgorithm]bool {
 
-	s := errText[e]
-	if s == "" {
-		return fmt.Sprintf("errno %d", int(e))
+	m := make(map[LzoAlgorithm]bool)
+	if initErr != nil {
+		return m
 	}
-	return s
-}
-
-var (
-	ErrOk                = Errno(0)
-	ErrError             = Errno(-1)
-	ErrOutOfMemory       = Errno(-2) /* [not used right now] */
-	ErrNotCompressible   = Errno(-3) /* [not used right now] */
-	ErrInputOverrun      = Errno(-4)
-	ErrOutputOverrun     = Errno(-5)
-	ErrLookbehindOverrun = Errno(-6)
-	ErrEofNotFound       = Errno(-7)
-	ErrInputNotConsumed  = Errno(-8)
-	ErrNotYetImplemented = Errno(-9) /* [not used right now] */
-)
 
-type LzoAlgorithm int
-
-const (
-	Lzo1x_1 LzoAlgorithm = iota
-	Lzo1x_999
-	BestSpeed          = Lzo1x_1
-	BestCompression    = Lzo1x_999
-	DefaultCompression = Lzo1x_999
-)
+	for _, a := range algorithms {
+		m[a] = built(a) && probe(newCompressor(a))
+	}
 
-type Compressor struct {
-	level      LzoAlgorithm
-	compress   func([]byte, []byte, *int, []byte) C.int
-	wrkmem_len int
+	return m
 }
 
-func init() {
-	if err := C.my_lzo_init(); err != 0 {
-		panic("lzo library initialization failed")
+// built reports whether the build tags left the functions for algorithm in
+func built(algorithm LzoAlgorithm) bool {
+	switch algorithm {
+	case Lzo1x_1:
+		return true
+	case Lzo1x_999:
+		return C.my_have_lzo1x_999 != 0
+	case Lzo2a_999:
+		return C.my_have_lzo2a != 0
 	}
+	return false
 }
 
+// NewCompressor returns a Compressor for the given algorithm, or an
+// UnsupportedAlgorithmError if the linked liblzo2 can't provide it.
 func NewCompressor(level LzoAlgorithm) (*Compressor, error) {
 
-	z := new(Compressor)
-	z.level = level
+	if initErr != nil {
+		return nil, initErr
+	}
+
+	if !isSupported(level) {
+		return nil, UnsupportedAlgorithmError(level)
+	}
+
+	return newCompressor(level), nil
+}
+
+func newCompressor(level LzoAlgorithm) *Compressor {
+
+	z := &Compressor{Decompressor: decompressorFor(level)}
 
 	switch z.level {
 	case Lzo1x_1:
 		z.compress = lzo1x_1_compress
+		z.output_size = lzo1x_output_size
 		z.wrkmem_len = int(C.lzo1x_1_mem_compress())
 	case Lzo1x_999:
 		z.compress = lzo1x_999_compress
+		z.output_size = lzo1x_output_size
 		z.wrkmem_len = int(C.lzo1x_999_mem_compress())
+	case Lzo2a_999:
+		z.compress = lzo2a_999_compress
+		z.output_size = lzo2a_output_size
+		z.wrkmem_len = int(C.lzo2a_999_mem_compress())
 	}
 
-	return z, nil
+	return z
+}
+
+// newDecompressor returns a Decompressor for the given algorithm.  The
+// lzo1x decompressors are in every liblzo2 build, so the lzo1x family
+// decompresses even if its compressors are missing.
+func newDecompressor(level LzoAlgorithm) (Decompressor, error) {
+
+	if initErr != nil {
+		return Decompressor{}, initErr
+	}
+
+	switch {
+	case level == Lzo1x_1, level == Lzo1x_999, isSupported(level):
+		return decompressorFor(level), nil
+	}
+
+	return Decompressor{}, UnsupportedAlgorithmError(level)
+}
+
+func decompressorFor(level LzoAlgorithm) Decompressor {
+
+	z := Decompressor{level: level}
+
+	switch level {
+	case Lzo1x_1, Lzo1x_999:
+		z.decompress = lzo1x_decompress
+		z.decompress_safe = lzo1x_decompress_safe
+	case Lzo2a_999:
+		z.decompress = lzo2a_decompress
+		z.decompress_safe = lzo2a_decompress_safe
+	}
+
+	return z
+}
+
+// Available reports whether the LZO library initialized successfully.  If it
+// didn't, NewCompressor returns the reason.  A library missing altogether
+// stops a dynamically linked program before it runs, which no Go code can
+// catch; build without cgo to avoid depending on it.
+func Available() bool {
+	return initErr == nil
 }
 
 // Version returns the version of the LZO library being used
@@ -112,59 +290,104 @@ func Version() string {
 	return C.GoString(p)
 }
 
-// Compress compresses a byte array and returns the compressed stream
-func (z *Compressor) Compress(b []byte) ([]byte, error) {
+// LibraryVersion returns the LZO_VERSION, LZO_VERSION_STRING and
+// LZO_VERSION_DATE of the LZO headers the package was built against.
+// Version reports the library actually linked, which may be newer.
+func LibraryVersion() (num uint, str string, date string) {
+	return uint(C.my_lzo_version()), C.GoString(C.my_lzo_version_string()), C.GoString(C.my_lzo_version_date())
+}
 
-	// our output buffer, sized to contain a worst-case compression
-	out_size := lzo1x_1_output_size(len(b))
-	out := make([]byte, out_size)
+// wrap the C calls so we can store a function pointer to them
+func lzo1x_1_compress(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+	return int(C.lzo1x_1_compress(bytePtr(b), C.lzo_uint(len(b)),
+		(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
+		unsafe.Pointer(&wrkmem[0])))
+}
 
-	out_size = 0 // here it's used to store the size of the compressed data
+func lzo1x_999_compress(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+	return int(C.my_lzo1x_999_compress(bytePtr(b), C.lzo_uint(len(b)),
+		(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
+		unsafe.Pointer(&wrkmem[0])))
+}
 
-	var err C.int
-	wrkmem := make([]byte, z.wrkmem_len)
-	err = z.compress(b, out, &out_size, wrkmem)
+// lzo1x_999_compress_level returns a compress function using the given
+// level; lzo1x_999_compress itself is level 8
+func lzo1x_999_compress_level(level int) func([]byte, []byte, *int, []byte) int {
+	return lzo1x_999_compress_dict(level, nil)
+}
 
-	// compression failed :(
-	if err != 0 {
-		return out[0:out_size], Errno(err)
+// lzo1x_999_compress_dict returns a compress function using the given
+// level and preset dictionary
+func lzo1x_999_compress_dict(level int, dict []byte) func([]byte, []byte, *int, []byte) int {
+	return func(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+		return int(C.my_lzo1x_999_compress_level(bytePtr(b), C.lzo_uint(len(b)),
+			(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
+			unsafe.Pointer(&wrkmem[0]), bytePtr(dict), C.lzo_uint(len(dict)), C.int(level)))
 	}
-
-	return out[0:out_size], nil
 }
 
-// Decompress decompresses the byte array b passed in into the byte array o, and returns the size of the valid uncompressed data.
-// If o is not large enough to hold the  compressed data, an error is returned.
-func (z *Compressor) Decompress(b []byte, o []byte) (uint, error) {
+func lzo2a_999_compress(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+	return int(C.my_lzo2a_999_compress(bytePtr(b), C.lzo_uint(len(b)),
+		(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
+		unsafe.Pointer(&wrkmem[0])))
+}
 
-	// both and input param (size of 'o') and output param (decompressed size)
-	out_size := uint(len(o))
+func lzo1x_decompress(b []byte, o []byte) (uint, int) {
+	r := C.my_lzo1x_decompress(bytePtr(b), C.lzo_uint(len(b)), bytePtr(o), C.lzo_uint(len(o)))
+	return uint(r.len), int(r.err)
+}
 
-	err := C.lzo1x_decompress((*C.uchar)(unsafe.Pointer(&b[0])), C.lzo_uint(len(b)),
-		(*C.uchar)(unsafe.Pointer(&o[0])), (*C.lzo_uint)(unsafe.Pointer(&out_size)), nil)
+func lzo2a_decompress(b []byte, o []byte) (uint, int) {
+	r := C.my_lzo2a_decompress(bytePtr(b), C.lzo_uint(len(b)), bytePtr(o), C.lzo_uint(len(o)))
+	return uint(r.len), int(r.err)
+}
 
-	// decompression failed :(
-	if err != 0 {
-		return out_size, Errno(err)
-	}
+func lzo1x_decompress_safe(b []byte, o []byte) (uint, int) {
+	r := C.my_lzo1x_decompress_safe(bytePtr(b), C.lzo_uint(len(b)), bytePtr(o), C.lzo_uint(len(o)))
+	return uint(r.len), int(r.err)
+}
 
-	return out_size, nil
+func lzo2a_decompress_safe(b []byte, o []byte) (uint, int) {
+	r := C.my_lzo2a_decompress_safe(bytePtr(b), C.lzo_uint(len(b)), bytePtr(o), C.lzo_uint(len(o)))
+	return uint(r.len), int(r.err)
 }
 
-// for an input of n, what is the worst-case compression we might get
-func lzo1x_1_output_size(n int) int {
-	return (n + n/16 + 64 + 3)
+// lzo1x_optimize optimizes the lzo1x data in c in place, using o, which
+// must hold the whole uncompressed data, as scratch space.  Without the
+// library's optimizer the data is left as it is, as in the pure Go build.
+func lzo1x_optimize(c []byte, o []byte) (uint, int) {
+	if C.my_have_lzo1x_999 == 0 {
+		return uint(len(o)), 0
+	}
+	r := C.my_lzo1x_optimize(bytePtr(c), C.lzo_uint(len(c)), bytePtr(o), C.lzo_uint(len(o)))
+	return uint(r.len), int(r.err)
 }
 
-// wrap the C calls so we can store a function pointer to them
-func lzo1x_1_compress(b []byte, out []byte, out_size *int, wrkmem []byte) C.int {
-	return C.lzo1x_1_compress((*C.uchar)(unsafe.Pointer(&b[0])), C.lzo_uint(len(b)),
-		(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
-		unsafe.Pointer(&wrkmem[0]))
+// lzo1x_decompress_dict_safe returns a safe decompress function using the
+// given preset dictionary, which is the pure Go one when the library's is
+// left out
+func lzo1x_decompress_dict_safe(dict []byte) func([]byte, []byte) (uint, int) {
+	if C.my_have_lzo1x_999 == 0 {
+		return func(b []byte, o []byte) (uint, int) {
+			_, n, err := lzo1x_decode_dict(b, o, dict)
+			return uint(n), int(err)
+		}
+	}
+	return func(b []byte, o []byte) (uint, int) {
+		r := C.my_lzo1x_decompress_dict_safe(bytePtr(b), C.lzo_uint(len(b)), bytePtr(o), C.lzo_uint(len(o)),
+			bytePtr(dict), C.lzo_uint(len(dict)))
+		return uint(r.len), int(r.err)
+	}
 }
 
-func lzo1x_999_compress(b []byte, out []byte, out_size *int, wrkmem []byte) C.int {
-	return C.lzo1x_999_compress((*C.uchar)(unsafe.Pointer(&b[0])), C.lzo_uint(len(b)),
-		(*C.uchar)(unsafe.Pointer(&out[0])), (*C.lzo_uint)(unsafe.Pointer(out_size)),
-		unsafe.Pointer(&wrkmem[0]))
+// bytePtr returns a pointer to the start of b for passing to C.  The
+// decompressors are called with whatever buffers the caller has, and the
+// compressors with whatever input, so an empty slice, where &b[0] would
+// panic, becomes a nil pointer; the library checks the length before
+// touching it.
+func bytePtr(b []byte) *C.uchar {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.uchar)(unsafe.Pointer(&b[0]))
 }
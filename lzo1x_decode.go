@@ -0,0 +1,226 @@
+package lzo
+
+// A pure Go lzo1x decompressor.  It follows lzo1x_decompress_safe: every
+// read from the input and every write to the output is bounds checked, so
+// corrupt or malicious input produces an error rather than a crash.
+
+// lzo1x_decode decompresses the lzo1x stream in src into dst, returning the
+// number of bytes consumed from src and written to dst.
+func lzo1x_decode(src []byte, dst []byte) (int, int, Errno) {
+	return lzo1x_decode_from(src, dst, 0, false)
+}
+
+// lzo1x_decode_prefix is lzo1x_decode, but stops without error once dst is
+// full, even partway through an instruction.  The count consumed from src
+// is then only approximate.
+func lzo1x_decode_prefix(src []byte, dst []byte) (int, int, Errno) {
+	return lzo1x_decode_from(src, dst, 0, true)
+}
+
+// lzo1x_decode_dict is lzo1x_decode with a preset dictionary, which matches
+// at the start of the output may refer back into.  Like
+// lzo1x_decompress_dict_safe only the last M4_MAX_OFFSET bytes of dict are
+// reachable.
+func lzo1x_decode_dict(src []byte, dst []byte, dict []byte) (int, int, Errno) {
+
+	if len(dict) > 0xbfff {
+		dict = dict[len(dict)-0xbfff:]
+	}
+
+	buf := make([]byte, len(dict)+len(dst))
+	copy(buf, dict)
+
+	ip, op, err := lzo1x_decode_from(src, buf, len(dict), false)
+	n := copy(dst, buf[len(dict):op])
+
+	return ip, n, err
+}
+
+// lzo1x_decode_from decodes into dst starting at op, with dst[:op] as
+// history that matches can refer back into.  The returned output position
+// includes that history.  If prefix is set, output that doesn't fit in dst
+// is dropped rather than being an error, and decoding stops once dst is
+// full.
+func lzo1x_decode_from(src []byte, dst []byte, op int, prefix bool) (int, int, Errno) {
+
+	ip := 0
+
+	// state is the number of literals copied right before the next
+	// instruction: 0, 1-3 from the low bits of a match, or 4 for a longer
+	// literal run.  It decides how an instruction below 16 is decoded.
+	state := 0
+
+	if len(src) == 0 {
+		return ip, op, ErrInputOverrun
+	}
+
+	if src[0] > 17 {
+		t := int(src[0]) - 17
+		ip++
+		if ip+t > len(src) {
+			return ip, op, ErrInputOverrun
+		}
+		if op+t > len(dst) && !prefix {
+			return ip, op, ErrOutputOverrun
+		}
+		op += copy(dst[op:], src[ip:ip+t])
+		ip += t
+		state = t
+		if state > 4 {
+			state = 4
+		}
+	}
+
+	for {
+		if prefix && op == len(dst) {
+			return ip, op, ErrOk
+		}
+
+		if ip >= len(src) {
+			return ip, op, ErrEofNotFound
+		}
+
+		t := int(src[ip])
+		ip++
+
+		var dist, length int
+		var err Errno
+
+		switch {
+		case t >= 64:
+			// M2: 3-8 bytes, distance up to 2 KiB
+			if ip >= len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + ((t >> 2) & 7) + int(src[ip])<<3
+			ip++
+			length = (t >> 5) + 1
+
+		case t >= 32:
+			// M3: distance up to 16 KiB
+			length = t & 31
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 31); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 2
+			if ip+2 > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + int(src[ip])>>2 + int(src[ip+1])<<6
+			ip += 2
+
+		case t >= 16:
+			// M4: distance up to 48 KiB, or the end-of-stream marker
+			dist = (t & 8) << 11
+			length = t & 7
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 7); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 2
+			if ip+2 > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist += int(src[ip])>>2 + int(src[ip+1])<<6
+			ip += 2
+
+			if dist == 0 {
+				if ip < len(src) {
+					return ip, op, ErrInputNotConsumed
+				}
+				return ip, op, ErrOk
+			}
+			dist += 0x4000
+
+		case state == 0:
+			// a literal run
+			length = t
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 15); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 3
+			if ip+length > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			if op+length > len(dst) && !prefix {
+				return ip, op, ErrOutputOverrun
+			}
+			op += copy(dst[op:], src[ip:ip+length])
+			ip += length
+			state = 4
+			continue
+
+		default:
+			// M1: a short match following literals
+			if ip >= len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + (t >> 2) + int(src[ip])<<2
+			ip++
+			length = 2
+			if state == 4 {
+				dist += 0x0800
+				length = 3
+			}
+		}
+
+		if dist > op {
+			return ip, op, ErrLookbehindOverrun
+		}
+		if op+length > len(dst) {
+			if !prefix {
+				return ip, op, ErrOutputOverrun
+			}
+			length = len(dst) - op
+		}
+
+		// the match may overlap the bytes it produces, so copy forwards one
+		// byte at a time
+		for m := op - dist; length > 0; length-- {
+			dst[op] = dst[m]
+			op++
+			m++
+		}
+
+		// the low bits of the instruction's last byte hold a count of
+		// literals that follow the match
+		state = int(src[ip-2]) & 3
+		if state > 0 {
+			if ip+state > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			if op+state > len(dst) && !prefix {
+				return ip, op, ErrOutputOverrun
+			}
+			op += copy(dst[op:], src[ip:ip+state])
+			ip += state
+		}
+	}
+}
+
+// lzo1x_decode_length reads the zero-run-extended length that follows an
+// instruction whose length field was 0
+func lzo1x_decode_length(src []byte, ip int, base int) (int, int, Errno) {
+
+	n := 0
+	for {
+		if ip >= len(src) {
+			return n, ip, ErrInputOverrun
+		}
+		if src[ip] != 0 {
+			break
+		}
+		n += 255
+		ip++
+	}
+
+	n += base + int(src[ip])
+	ip++
+
+	return n, ip, ErrOk
+}
@@ -0,0 +1,152 @@
+package lzo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lzo1x streams assembled by hand, one instruction at a time, to exercise
+// each kind the decoder handles.  The first two are what liblzo's
+// lzo1x_1_compress writes for those inputs, since it emits inputs of up to
+// 13 bytes as a single literal run.
+var lzo1x_streams = []struct {
+	name string
+	in   []byte
+	out  string
+}{
+	{"empty", []byte{0x11, 0, 0}, ""},
+	{"literals", []byte{17 + 5, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0}, "hello"},
+	{
+		// M2: length 8 at distance 4
+		"m2",
+		[]byte{17 + 4, 'a', 'b', 'c', 'd', 7<<5 | 3<<2, 0, 0x11, 0, 0},
+		"abcdabcdabcd",
+	},
+	{
+		// M3: length 30 at distance 4
+		"m3",
+		[]byte{17 + 4, 'a', 'b', 'c', 'd', 32 | 28, 3 << 2, 0, 0x11, 0, 0},
+		strings.Repeat("abcd", 9)[:34],
+	},
+	{
+		// M3 whose length, 298, continues into a zero byte
+		"m3 long",
+		[]byte{17 + 4, 'a', 'b', 'c', 'd', 32, 0, 10, 3 << 2, 0, 0x11, 0, 0},
+		strings.Repeat("abcd", 76)[:302],
+	},
+	{
+		// M3 then a literal run of 5, from state 0
+		"literal run",
+		[]byte{17 + 4, 'a', 'b', 'c', 'd', 32 | 2, 3 << 2, 0, 2, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0},
+		"abcdabcdhello",
+	},
+	{
+		// M2 with 1 trailing literal, then M1: length 2 at distance 3
+		"m1",
+		[]byte{17 + 4, 'a', 'b', 'c', 'd', 2<<5 | 3<<2 | 1, 0, 'X', 2 << 2, 0, 0x11, 0, 0},
+		"abcdabcXbc",
+	},
+	{
+		// a 16400 byte M3 at distance 2, then M4: length 9 at
+		// distance 16386
+		"m4",
+		append(append([]byte{17 + 2, 'a', 'b', 32}, make([]byte, 64)...), 47, 1<<2, 0, 0x10|7, 2<<2, 0, 0x11, 0, 0),
+		strings.Repeat("ab", 16411/2+1)[:16411],
+	},
+}
+
+func TestDecodeStreams(t *testing.T) {
+
+	for _, tt := range lzo1x_streams {
+		dst := make([]byte, len(tt.out))
+		ip, op, err := lzo1x_decode(tt.in, dst)
+		if err != ErrOk || ip != len(tt.in) || string(dst[:op]) != tt.out {
+			t.Errorf("%s: got %d, %d, %v", tt.name, ip, op, err)
+		}
+
+		// the scanner must agree without producing output
+		n, size, err := lzo1x_scan(tt.in)
+		if err != ErrOk || n != len(tt.in) || size != len(tt.out) {
+			t.Errorf("%s: scan got %d, %d, %v", tt.name, n, size, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+
+	hello := []byte{17 + 5, 'h', 'e', 'l', 'l', 'o', 0x11, 0, 0}
+
+	tests := []struct {
+		name string
+		in   []byte
+		dst  int
+		err  Errno
+	}{
+		{"empty input", nil, 16, ErrInputOverrun},
+		{"truncated literals", hello[:4], 16, ErrInputOverrun},
+		{"no end marker", hello[:6], 16, ErrEofNotFound},
+		{"truncated end marker", hello[:8], 16, ErrInputOverrun},
+		{"trailing data", append(hello[:9:9], 0), 16, ErrInputNotConsumed},
+		{"output too small", hello, 4, ErrOutputOverrun},
+		{"match before the start", []byte{17 + 1, 'a', 2<<5 | 3<<2, 0, 0x11, 0, 0}, 16, ErrLookbehindOverrun},
+		{"truncated length", []byte{17 + 4, 'a', 'b', 'c', 'd', 32, 0, 0}, 16, ErrInputOverrun},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := lzo1x_decode(tt.in, make([]byte, tt.dst)); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+
+	for _, in := range testInputs() {
+		c := lzo1x_1_encode(nil, in)
+		if len(c) > lzo1x_output_size(len(in)) {
+			t.Errorf("%d bytes compressed to %d, more than the worst case", len(in), len(c))
+		}
+
+		dst := make([]byte, len(in))
+		ip, op, err := lzo1x_decode(c, dst)
+		if err != ErrOk || ip != len(c) || !bytes.Equal(dst[:op], in) {
+			t.Errorf("%d bytes: round trip failed: %v", len(in), err)
+		}
+	}
+}
+
+func FuzzDecompress(f *testing.F) {
+
+	for _, tt := range lzo1x_streams {
+		f.Add(tt.in)
+	}
+	for _, in := range testInputs()[:4] {
+		f.Add(lzo1x_1_encode(nil, in))
+	}
+	files, _ := filepath.Glob(filepath.Join("testdata", "malformed", "*"))
+	for _, file := range files {
+		if in, err := os.ReadFile(file); err == nil {
+			f.Add(in)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		dst := make([]byte, 1<<16)
+		ip, op, err := lzo1x_decode(in, dst)
+		if ip > len(in) || op > len(dst) {
+			t.Fatalf("decoded %d of %d bytes into %d of %d", ip, len(in), op, len(dst))
+		}
+		if err != ErrOk {
+			return
+		}
+
+		// whatever decodes must also scan to the same sizes
+		n, size, err := lzo1x_scan(in)
+		if err != ErrOk || n != ip || size != op {
+			t.Fatalf("scan got %d, %d, %v; decode got %d, %d", n, size, err, ip, op)
+		}
+	})
+}
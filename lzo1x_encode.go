@@ -0,0 +1,132 @@
+package lzo
+
+import "encoding/binary"
+
+// A pure Go lzo1x_1 compressor.  It uses the same single-probe hash table
+// strategy as the library's lzo1x_1, but makes no attempt to reproduce its
+// output bit for bit; the result is simply a valid lzo1x stream.
+
+const (
+	lzo1x_1_dict_bits = 14
+
+	m2_max_len    = 8
+	m2_max_offset = 0x0800
+	m3_max_len    = 33
+	m3_max_offset = 0x4000
+	m4_max_len    = 9
+	m4_max_offset = 0xbfff
+)
+
+// lzo1x_1_encode appends the lzo1x compression of src to dst.  If dst has
+// room for lzo1x_output_size(len(src)) more bytes it is never reallocated.
+func lzo1x_1_encode(dst []byte, src []byte) []byte {
+
+	start := len(dst)
+
+	// ii marks the start of the pending literals
+	ii := 0
+
+	if len(src) > 20 {
+		var dict [1 << lzo1x_1_dict_bits]int32
+
+		ip_end := len(src) - 20
+
+		// start far enough in that the first literal run is at least 4
+		// bytes and can't be confused with the short form after a match
+		ip := 4
+
+		for {
+			// skip faster through data that isn't matching
+			ip += 1 + (ip-ii)>>5
+
+		next:
+			if ip >= ip_end {
+				break
+			}
+
+			dv := binary.LittleEndian.Uint32(src[ip:])
+			h := (dv * 0x1824429d) >> (32 - lzo1x_1_dict_bits)
+			m_pos := int(dict[h])
+			dict[h] = int32(ip)
+
+			m_off := ip - m_pos
+			if m_off == 0 || m_off > m4_max_offset || dv != binary.LittleEndian.Uint32(src[m_pos:]) {
+				continue
+			}
+
+			dst = lzo1x_encode_literals(dst, src[ii:ip], len(dst) == start)
+
+			m_len := 4
+			for ip+m_len < ip_end && src[ip+m_len] == src[m_pos+m_len] {
+				m_len++
+			}
+
+			ip += m_len
+			ii = ip
+
+			switch {
+			case m_len <= m2_max_len && m_off <= m2_max_offset:
+				m_off--
+				dst = append(dst, byte((m_len-1)<<5|(m_off&7)<<2), byte(m_off>>3))
+			case m_off <= m3_max_offset:
+				m_off--
+				if m_len <= m3_max_len {
+					dst = append(dst, byte(32|(m_len-2)))
+				} else {
+					dst = append(dst, 32)
+					dst = lzo1x_encode_length(dst, m_len-m3_max_len)
+				}
+				dst = append(dst, byte(m_off<<2), byte(m_off>>6))
+			default:
+				m_off -= 0x4000
+				if m_len <= m4_max_len {
+					dst = append(dst, byte(16|(m_off>>11)&8|(m_len-2)))
+				} else {
+					dst = append(dst, byte(16|(m_off>>11)&8))
+					dst = lzo1x_encode_length(dst, m_len-m4_max_len)
+				}
+				dst = append(dst, byte(m_off<<2), byte(m_off>>6))
+			}
+
+			goto next
+		}
+	}
+
+	dst = lzo1x_encode_literals(dst, src[ii:], len(dst) == start)
+
+	// end-of-stream marker: an M4 match with a distance of zero
+	return append(dst, 16|1, 0, 0)
+}
+
+// lzo1x_encode_literals appends a run of literals.  Runs of 1-3 bytes are
+// folded into the low bits of the preceding match instruction, so they may
+// only follow a match.
+func lzo1x_encode_literals(dst []byte, lit []byte, first bool) []byte {
+
+	t := len(lit)
+
+	switch {
+	case t == 0:
+		return dst
+	case first && t <= 238:
+		dst = append(dst, byte(17+t))
+	case t <= 3:
+		dst[len(dst)-2] |= byte(t)
+	case t <= 18:
+		dst = append(dst, byte(t-3))
+	default:
+		dst = append(dst, 0)
+		dst = lzo1x_encode_length(dst, t-18)
+	}
+
+	return append(dst, lit...)
+}
+
+// lzo1x_encode_length appends the zero-run-extended form of n
+func lzo1x_encode_length(dst []byte, n int) []byte {
+	for n > 255 {
+		dst = append(dst, 0)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
@@ -0,0 +1,203 @@
+package lzo
+
+import "math/bits"
+
+// StreamStats describes the instructions of an lzo1x stream, as reported
+// by AnalyzeStream.
+type StreamStats struct {
+	CompressedSize   int
+	UncompressedSize int
+
+	// LiteralRuns counts the runs of bytes copied from the stream as they
+	// are, including the up to 3 that can follow a match; Literals is the
+	// total number of those bytes.
+	LiteralRuns int
+	Literals    int
+
+	// Matches counts the copies of earlier output.  MatchLengths[i] counts
+	// those between 1<<(i+1) and 1<<(i+2)-1 bytes long, so MatchLengths[0]
+	// holds lengths 2 and 3, the last entry also taking anything longer.
+	Matches         int
+	MatchLengths    [8]int
+	AverageDistance float64
+
+	distances int64
+}
+
+// AnalyzeStream walks the lzo1x stream compressed, without decompressing
+// it, and reports what it is made of.  Mostly literals or short matches
+// suggest data that lzo1x_1 finds little redundancy in; Lzo1x_999 or a
+// larger block size may do better.  It is meant for diagnosis only.
+func AnalyzeStream(compressed []byte) (StreamStats, error) {
+
+	var st StreamStats
+
+	n, size, err := lzo1x_walk(compressed, &st)
+	if err != ErrOk {
+		return st, err
+	}
+
+	st.CompressedSize = n
+	st.UncompressedSize = size
+	if st.Matches > 0 {
+		st.AverageDistance = float64(st.distances) / float64(st.Matches)
+	}
+
+	return st, nil
+}
+
+// addLiterals records a run of n literals in st
+func (st *StreamStats) addLiterals(n int) {
+	if st != nil && n > 0 {
+		st.LiteralRuns++
+		st.Literals += n
+	}
+}
+
+// addMatch records a match in st
+func (st *StreamStats) addMatch(length int, dist int) {
+	if st != nil {
+		st.Matches++
+		b := bits.Len(uint(length)) - 2
+		if b >= len(st.MatchLengths) {
+			b = len(st.MatchLengths) - 1
+		}
+		st.MatchLengths[b]++
+		st.distances += int64(dist)
+	}
+}
+
+// lzo1x_scan walks the instructions of the lzo1x stream at the start of src
+// without producing any output.  It returns the length of the stream,
+// including its end-of-stream marker, and the size it decompresses to.
+// Anything in src after the marker is ignored.
+func lzo1x_scan(src []byte) (int, int, Errno) {
+	return lzo1x_walk(src, nil)
+}
+
+// lzo1x_walk is lzo1x_scan, also recording the instructions in st if it
+// isn't nil
+func lzo1x_walk(src []byte, st *StreamStats) (int, int, Errno) {
+
+	ip, op := 0, 0
+
+	// see lzo1x_decode
+	state := 0
+
+	if len(src) == 0 {
+		return ip, op, ErrInputOverrun
+	}
+
+	if src[0] > 17 {
+		t := int(src[0]) - 17
+		ip += 1 + t
+		op += t
+		st.addLiterals(t)
+		if ip > len(src) {
+			return len(src), op, ErrInputOverrun
+		}
+		state = t
+		if state > 4 {
+			state = 4
+		}
+	}
+
+	for {
+		if ip >= len(src) {
+			return ip, op, ErrEofNotFound
+		}
+
+		t := int(src[ip])
+		ip++
+
+		var dist, length int
+		var err Errno
+
+		switch {
+		case t >= 64:
+			if ip >= len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + ((t >> 2) & 7) + int(src[ip])<<3
+			ip++
+			length = (t >> 5) + 1
+
+		case t >= 32:
+			length = t & 31
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 31); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 2
+			if ip+2 > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + int(src[ip])>>2 + int(src[ip+1])<<6
+			ip += 2
+
+		case t >= 16:
+			dist = (t & 8) << 11
+			length = t & 7
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 7); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 2
+			if ip+2 > len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist += int(src[ip])>>2 + int(src[ip+1])<<6
+			ip += 2
+
+			if dist == 0 {
+				return ip, op, ErrOk
+			}
+			dist += 0x4000
+
+		case state == 0:
+			length = t
+			if length == 0 {
+				if length, ip, err = lzo1x_decode_length(src, ip, 15); err != 0 {
+					return ip, op, err
+				}
+			}
+			length += 3
+			ip += length
+			op += length
+			st.addLiterals(length)
+			if ip > len(src) {
+				return len(src), op, ErrInputOverrun
+			}
+			state = 4
+			continue
+
+		default:
+			if ip >= len(src) {
+				return ip, op, ErrInputOverrun
+			}
+			dist = 1 + (t >> 2) + int(src[ip])<<2
+			ip++
+			length = 2
+			if state == 4 {
+				dist += 0x0800
+				length = 3
+			}
+		}
+
+		if dist > op {
+			return ip, op, ErrLookbehindOverrun
+		}
+		op += length
+		st.addMatch(length, dist)
+
+		state = int(src[ip-2]) & 3
+		ip += state
+		op += state
+		st.addLiterals(state)
+		if ip > len(src) {
+			return len(src), op, ErrInputOverrun
+		}
+	}
+}
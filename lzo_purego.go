@@ -0,0 +1,96 @@
+//go:build !cgo
+
+package lzo
+
+// Without cgo the lzo1x family can be decompressed, but only lzo1x_1 can
+// compress; lzo1x_999 needs the library.
+
+// the pure Go code takes any length
+const lzo_uint_max = ^uint64(0)
+
+func probeAlgorithms() map[LzoAlgorithm]bool {
+	return map[LzoAlgorithm]bool{Lzo1x_1: true}
+}
+
+// NewCompressor returns a Compressor for the given algorithm.  Without cgo
+// only Lzo1x_1 compresses, and other algorithms return an
+// UnsupportedAlgorithmError; NewDecompressor still decodes Lzo1x_999 data.
+func NewCompressor(level LzoAlgorithm) (*Compressor, error) {
+
+	if level != Lzo1x_1 {
+		return nil, UnsupportedAlgorithmError(level)
+	}
+
+	d, _ := newDecompressor(level)
+	z := &Compressor{Decompressor: d}
+	z.compress = lzo1x_1_compress
+	z.output_size = lzo1x_output_size
+
+	return z, nil
+}
+
+// newDecompressor returns a Decompressor for the given algorithm.  Without
+// cgo only the lzo1x family can be decompressed.
+func newDecompressor(level LzoAlgorithm) (Decompressor, error) {
+
+	switch level {
+	case Lzo1x_1, Lzo1x_999:
+		return Decompressor{level: level, decompress: lzo1x_decompress, decompress_safe: lzo1x_decompress}, nil
+	}
+
+	return Decompressor{}, UnsupportedAlgorithmError(level)
+}
+
+// Available reports whether the LZO library is in use, which without cgo it
+// never is.
+func Available() bool {
+	return false
+}
+
+// Version returns the version of the LZO library being used.  Without cgo
+// no library is linked and the pure Go implementation reports "purego".
+func Version() string {
+	return "purego"
+}
+
+// LibraryVersion returns the version of the LZO headers the package was
+// built against.  Without cgo there are none, and it returns 0, "purego"
+// and an empty date.
+func LibraryVersion() (num uint, str string, date string) {
+	return 0, "purego", ""
+}
+
+func lzo1x_1_compress(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+	*out_size = len(lzo1x_1_encode(out[:0], b))
+	return 0
+}
+
+func lzo1x_compress_unavailable(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+	return int(ErrNotYetImplemented)
+}
+
+func lzo1x_999_compress_level(level int) func([]byte, []byte, *int, []byte) int {
+	return lzo1x_compress_unavailable
+}
+
+func lzo1x_999_compress_dict(level int, dict []byte) func([]byte, []byte, *int, []byte) int {
+	return lzo1x_compress_unavailable
+}
+
+// without the library there is no optimizer, and the data is left as it is
+func lzo1x_optimize(c []byte, o []byte) (uint, int) {
+	return uint(len(o)), 0
+}
+
+// the pure Go decompressor is always bounds checked
+func lzo1x_decompress(b []byte, o []byte) (uint, int) {
+	_, n, err := lzo1x_decode(b, o)
+	return uint(n), int(err)
+}
+
+func lzo1x_decompress_dict_safe(dict []byte) func([]byte, []byte) (uint, int) {
+	return func(b []byte, o []byte) (uint, int) {
+		_, n, err := lzo1x_decode_dict(b, o, dict)
+		return uint(n), int(err)
+	}
+}
@@ -0,0 +1,906 @@
+package lzo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Support for the file format of the lzop command line tool.  A file is a
+// magic number, a header describing the original file, and a series of
+// blocks, each preceded by its uncompressed and compressed sizes and
+// optional checksums, ending with a block of uncompressed size zero.  All
+// lzop methods produce lzo1x data, except the stored method, whose blocks
+// all hold their data uncompressed.
+
+var lzopMagic = [...]byte{0x89, 'L', 'Z', 'O', 0x00, 0x0d, 0x0a, 0x1a, 0x0a}
+
+// lzop header flags
+const (
+	lzopAdler32D    = 0x00000001
+	lzopAdler32C    = 0x00000002
+	lzopExtraField  = 0x00000040
+	lzopCRC32D      = 0x00000100
+	lzopCRC32C      = 0x00000200
+	lzopFilter      = 0x00000800
+	lzopHeaderCRC32 = 0x00001000
+	lzopFlagMask    = 0x00003fff
+	lzopOSMask      = 0xff000000
+	lzopCharsetMask = 0x00f00000
+)
+
+// lzop methods
+const (
+	lzopStored     = 0
+	lzopLzo1x_1    = 1
+	lzopLzo1x_1_15 = 2
+	lzopLzo1x_999  = 3
+)
+
+const (
+	// the newest format version we understand, that of lzop 1.04
+	lzopVersion = 0x1040
+
+	// lzop refuses blocks larger than this
+	lzopMaxBlockSize = 64 * 1024 * 1024
+
+	// the largest extra field we read; lzop itself never writes one
+	lzopMaxExtraSize = 64 * 1024
+)
+
+// An LzopHeader holds the header of an lzop file, which describes the file
+// that was compressed.
+type LzopHeader struct {
+	Version       uint16 // of the lzop that wrote the file
+	LibVersion    uint16 // of the LZO library it used
+	VersionNeeded uint16 // to extract the file
+	Method        byte
+	Level         byte
+	Flags         uint32
+	Mode          uint32 // the original file's permissions
+	ModTime       time.Time
+	Name          string // the original file name
+	Extra         []byte // the contents of the extra field, if any
+}
+
+// readLzopHeader reads an lzop header, including the magic number, from r
+func readLzopHeader(r io.Reader) (*LzopHeader, error) {
+
+	var magic [len(lzopMagic)]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != lzopMagic {
+		return nil, &HeaderError{Field: "magic", Reason: "not an lzop file"}
+	}
+
+	// the header checksum covers everything from here to the checksum
+	// itself, but which checksum is used isn't known until the flags
+	a := adler32.New()
+	c := crc32.NewIEEE()
+	hr := &lzopFieldReader{r: io.TeeReader(r, io.MultiWriter(a, c))}
+
+	h := new(LzopHeader)
+
+	h.Version = hr.read16()
+	h.LibVersion = hr.read16()
+	if h.Version >= 0x0940 {
+		h.VersionNeeded = hr.read16()
+	}
+	h.Method = hr.read8()
+	if h.Version >= 0x0940 {
+		h.Level = hr.read8()
+	}
+	h.Flags = hr.read32()
+	if h.Flags&lzopFilter != 0 {
+		if filter := hr.read32(); hr.err == nil && filter != 0 {
+			return nil, &HeaderError{Field: "filter", Reason: "filters are not supported"}
+		}
+	}
+	h.Mode = hr.read32()
+	mtime := int64(hr.read32())
+	if h.Version >= 0x0940 {
+		mtime |= int64(hr.read32()) << 32
+	}
+	h.ModTime = time.Unix(mtime, 0)
+	name := make([]byte, hr.read8())
+	hr.readFull(name)
+	h.Name = string(name)
+
+	if hr.err != nil {
+		return nil, noEOF(hr.err)
+	}
+
+	actual, kind := a.Sum32(), "adler32"
+	if h.Flags&lzopHeaderCRC32 != 0 {
+		actual, kind = c.Sum32(), "crc32"
+	}
+
+	hr.r = r
+	if expected := hr.read32(); hr.err == nil && expected != actual {
+		return nil, &ChecksumError{Expected: expected, Actual: actual, Kind: kind}
+	}
+
+	// the extra field has a checksum of its own
+	if h.Flags&lzopExtraField != 0 && hr.err == nil {
+		a.Reset()
+		c.Reset()
+		hr.r = io.TeeReader(r, io.MultiWriter(a, c))
+		n := hr.read32()
+		if hr.err != nil {
+			return nil, noEOF(hr.err)
+		}
+		if n > lzopMaxExtraSize {
+			return nil, &HeaderError{Field: "extra field", Reason: fmt.Sprintf("%d bytes is too long", n)}
+		}
+		h.Extra = make([]byte, n)
+		hr.readFull(h.Extra)
+		actual := a.Sum32()
+		if h.Flags&lzopHeaderCRC32 != 0 {
+			actual = c.Sum32()
+		}
+		hr.r = r
+		if expected := hr.read32(); hr.err == nil && expected != actual {
+			return nil, &ChecksumError{Expected: expected, Actual: actual, Kind: kind}
+		}
+	}
+
+	if hr.err != nil {
+		return nil, noEOF(hr.err)
+	}
+
+	// flags this reader doesn't know are ignored: none of them change the
+	// layout of the header or blocks
+	switch {
+	case h.Version < 0x0900:
+		return nil, &HeaderError{Field: "version", Reason: fmt.Sprintf("unknown version %#04x", h.Version)}
+	case h.VersionNeeded > lzopVersion:
+		return nil, &HeaderError{Field: "version", Reason: fmt.Sprintf("needs lzop version %#04x", h.VersionNeeded)}
+	}
+
+	switch h.Method {
+	case lzopStored, lzopLzo1x_1, lzopLzo1x_1_15, lzopLzo1x_999:
+	default:
+		return nil, &HeaderError{Field: "method", Reason: fmt.Sprintf("unknown compression method %d", h.Method)}
+	}
+
+	return h, nil
+}
+
+// lzopBlock is the header of one block of an lzop file
+type lzopBlock struct {
+	dst_len   uint32
+	src_len   uint32
+	d_adler32 uint32
+	d_crc32   uint32
+	c_adler32 uint32
+	c_crc32   uint32
+}
+
+// readLzopBlock reads a block header.  The block after the last one has a
+// dst_len of zero and nothing else.
+func readLzopBlock(r io.Reader, flags uint32) (lzopBlock, error) {
+
+	fr := &lzopFieldReader{r: r}
+
+	var b lzopBlock
+
+	b.dst_len = fr.read32()
+	if fr.err != nil || b.dst_len == 0 {
+		return b, noEOF(fr.err)
+	}
+
+	b.src_len = fr.read32()
+	if flags&lzopAdler32D != 0 {
+		b.d_adler32 = fr.read32()
+	}
+	if flags&lzopCRC32D != 0 {
+		b.d_crc32 = fr.read32()
+	}
+	// stored blocks don't repeat the checksums
+	if b.src_len < b.dst_len {
+		if flags&lzopAdler32C != 0 {
+			b.c_adler32 = fr.read32()
+		}
+		if flags&lzopCRC32C != 0 {
+			b.c_crc32 = fr.read32()
+		}
+	}
+
+	if fr.err != nil {
+		return b, noEOF(fr.err)
+	}
+
+	if b.src_len == 0 || b.src_len > b.dst_len || b.dst_len > lzopMaxBlockSize {
+		return b, errCorrupt
+	}
+
+	// nor can a block claim more than its data could decompress to, so a
+	// small file can't make DecodeLzopBytes allocate gigabytes
+	if !plausibleSize(int(b.src_len), uint64(b.dst_len)) {
+		return b, errCorrupt
+	}
+
+	return b, nil
+}
+
+// ReadLzopBlockHeader reads the header of the next block of an lzop file
+// from r, leaving r at the start of the block's compressed data.  flags are
+// the flags from the file header, which decide which checksums each block
+// header carries; the checksums are read past but not verified.  The end
+// of the blocks is reported as an uncompressedSize of zero with a nil
+// error.  A compressedSize equal to uncompressedSize means the block is
+// stored uncompressed.
+func ReadLzopBlockHeader(r io.Reader, flags uint32) (uncompressedSize, compressedSize uint32, err error) {
+	b, err := readLzopBlock(r, flags)
+	if err != nil {
+		return 0, 0, err
+	}
+	return b.dst_len, b.src_len, nil
+}
+
+// decode decompresses the block's data, src, into dst, which must be
+// exactly dst_len bytes, and checks its checksums.
+func (b *lzopBlock) decode(z *Compressor, h *LzopHeader, src []byte, dst []byte) error {
+
+	flags := h.Flags
+
+	if b.src_len < b.dst_len {
+		// a stored file has no compressed blocks
+		if h.Method == lzopStored {
+			return errCorrupt
+		}
+
+		if err := lzopCheck(flags, lzopAdler32C, lzopCRC32C, b.c_adler32, b.c_crc32, src); err != nil {
+			return err
+		}
+
+		if err := decodeBlock(z, src, dst); err != nil {
+			return err
+		}
+	} else {
+		copy(dst, src)
+	}
+
+	return lzopCheck(flags, lzopAdler32D, lzopCRC32D, b.d_adler32, b.d_crc32, dst)
+}
+
+// lzopCheck verifies whichever of the adler32 and crc32 checksums flags
+// says are present
+func lzopCheck(flags uint32, adler_flag uint32, crc_flag uint32, adler uint32, crc uint32, data []byte) error {
+
+	if flags&adler_flag != 0 {
+		if actual := adler32.Checksum(data); actual != adler {
+			return &ChecksumError{Expected: adler, Actual: actual, Kind: "adler32"}
+		}
+	}
+
+	if flags&crc_flag != 0 {
+		if actual := crc32.ChecksumIEEE(data); actual != crc {
+			return &ChecksumError{Expected: crc, Actual: actual, Kind: "crc32"}
+		}
+	}
+
+	return nil
+}
+
+// DecodeLzopBytes decompresses a complete lzop file held in memory.  The
+// output is allocated once, sized from the uncompressed sizes recorded in
+// the block headers, and every block checksum present is verified.  A file
+// of several concatenated members decompresses to their contents one after
+// the other.
+func DecodeLzopBytes(src []byte) ([]byte, error) {
+
+	r := bytes.NewReader(src)
+
+	h, err := readLzopHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// first find the blocks and add up their sizes
+	type block struct {
+		lzopBlock
+		h    *LzopHeader
+		data []byte
+	}
+
+	var blocks []block
+	size := 0
+
+	for {
+		b, err := readLzopBlock(r, h.Flags)
+		if err != nil {
+			return nil, err
+		}
+		if b.dst_len == 0 {
+			if r.Len() == 0 {
+				break
+			}
+			if h, err = readLzopHeader(r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		off := len(src) - r.Len()
+		if int(b.src_len) > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		r.Seek(int64(b.src_len), io.SeekCurrent)
+
+		blocks = append(blocks, block{b, h, src[off : off+int(b.src_len)]})
+		size += int(b.dst_len)
+	}
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, size)
+	pos := 0
+
+	for i := range blocks {
+		b := &blocks[i]
+		if err := b.decode(z, b.h, b.data, out[pos:pos+int(b.dst_len)]); err != nil {
+			return nil, &BlockError{Block: i, Err: err}
+		}
+		pos += int(b.dst_len)
+	}
+
+	return out, nil
+}
+
+// LzopInfo describes an lzop file as read by ReadLzopInfo.  The counts
+// cover every member of a file of several concatenated members, of which
+// Header is the first.
+type LzopInfo struct {
+	Header           *LzopHeader
+	Members          int
+	Blocks           int
+	UncompressedSize int64
+	CompressedSize   int64 // of the block data, not counting block headers
+}
+
+// ReadLzopInfo reads the header and block headers of an lzop file from r,
+// skipping the compressed data, to report the file's sizes without
+// decompressing it.  If r is an io.Seeker the data is seeked past rather
+// than read.  Checksums are not verified.
+func ReadLzopInfo(r io.Reader) (LzopInfo, error) {
+
+	var info LzopInfo
+
+	h, err := readLzopHeader(r)
+	if err != nil {
+		return info, err
+	}
+	info.Header = h
+	info.Members = 1
+
+	s, _ := r.(io.Seeker)
+
+	for {
+		b, err := readLzopBlock(r, h.Flags)
+		if err != nil {
+			return info, err
+		}
+		if b.dst_len == 0 {
+			h, err = readLzopHeader(r)
+			if err == io.EOF {
+				return info, nil
+			}
+			if err != nil {
+				return info, err
+			}
+			info.Members++
+			continue
+		}
+
+		if s != nil {
+			_, err = s.Seek(int64(b.src_len), io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(io.Discard, r, int64(b.src_len))
+		}
+		if err != nil {
+			return info, noEOF(err)
+		}
+
+		info.Blocks++
+		info.UncompressedSize += int64(b.dst_len)
+		info.CompressedSize += int64(b.src_len)
+	}
+}
+
+// An LzopReader decompresses a file in the format of the lzop tool.
+type LzopReader struct {
+	r       io.Reader
+	z       *Compressor
+	h       *LzopHeader
+	in      []byte
+	out     []byte
+	pending []byte
+	err     error
+}
+
+// NewLzopReader reads the lzop header from r and returns it along with a
+// Reader for the rest of the file.  A file written with the stored method is
+// copied through as is.  Like gzip, an lzop file may hold several
+// concatenated members, which are read one after the other as if they were
+// one; the header returned is the first member's.
+func NewLzopReader(r io.Reader) (*LzopReader, *LzopHeader, error) {
+
+	h, err := readLzopHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &LzopReader{r: r, z: z, h: h}, h, nil
+}
+
+// NewAutoReader looks at the magic number at the start of r and returns an
+// LzopReader for an lzop file or a Reader for an lzopack stream.  r is read
+// through a bufio.Reader, so it may be read past the end of the data.
+func NewAutoReader(r io.Reader) (io.ReadCloser, error) {
+
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(lzopMagic))
+	if bytes.HasPrefix(magic, lzopMagic[:]) {
+		zr, _, err := NewLzopReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+	if bytes.HasPrefix(magic, magicHeader[:]) {
+		zr, err := NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	return nil, &HeaderError{Field: "magic", Reason: "neither an lzop file nor an lzopack stream"}
+}
+
+// IsCompressed reports whether data starts like an lzop file or an lzopack
+// stream, or is an Encode blob, so that it can be left as it is rather than
+// compressed again.  lzop files and lzopack streams are recognised by
+// their magic numbers alone.  The two byte Encode magic is too short to
+// trust, so data must also hold a whole blob whose algorithm is known and
+// whose recorded length fits the size of its compressed data; the
+// contents aren't decompressed.
+func IsCompressed(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, lzopMagic[:]), bytes.HasPrefix(data, magicHeader[:]):
+		return true
+	case len(data) >= 7+4 && data[0] == encodeMagic && data[1] == encodeVersion:
+		if _, ok := algorithmNames[LzoAlgorithm(data[2])]; !ok {
+			return false
+		}
+		n := binary.BigEndian.Uint32(data[3:])
+		c := len(data) - 7 - 4
+		return plausibleSize(c, uint64(n)) && uint64(c) <= uint64(lzo2a_output_size(int(n)))
+	}
+	return false
+}
+
+// Read reads decompressed data from the file, verifying each block's
+// checksums.
+func (z *LzopReader) Read(p []byte) (int, error) {
+
+	for len(z.pending) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+	}
+
+	n := copy(p, z.pending)
+	z.pending = z.pending[n:]
+
+	return n, nil
+}
+
+// Close does not close the underlying io.Reader.
+func (z *LzopReader) Close() error {
+	return nil
+}
+
+// Header returns the header of the member being read.
+func (z *LzopReader) Header() *LzopHeader {
+	return z.h
+}
+
+func (z *LzopReader) readBlock() error {
+
+	b, err := readLzopBlock(z.r, z.h.Flags)
+	if err != nil {
+		return err
+	}
+	if b.dst_len == 0 {
+		// another member may follow; the end of r here is the end of the
+		// file, returned by readLzopHeader as io.EOF
+		h, err := readLzopHeader(z.r)
+		if err != nil {
+			return err
+		}
+		z.h = h
+		return nil
+	}
+
+	if cap(z.in) < int(b.src_len) {
+		z.in = make([]byte, b.src_len)
+	}
+	if cap(z.out) < int(b.dst_len) {
+		z.out = make([]byte, b.dst_len)
+	}
+
+	src := z.in[:b.src_len]
+	if _, err := io.ReadFull(z.r, src); err != nil {
+		return noEOF(err)
+	}
+
+	dst := z.out[:b.dst_len]
+	if err := b.decode(z.z, z.h, src, dst); err != nil {
+		return err
+	}
+
+	z.pending = dst
+
+	return nil
+}
+
+// lzopFieldReader reads big-endian header fields, remembering the first
+// error so a run of reads needs only one check
+type lzopFieldReader struct {
+	r   io.Reader
+	buf [4]byte
+	err error
+}
+
+func (f *lzopFieldReader) readFull(b []byte) {
+	if f.err == nil {
+		_, f.err = io.ReadFull(f.r, b)
+	}
+}
+
+func (f *lzopFieldReader) read8() byte {
+	f.readFull(f.buf[:1])
+	return f.buf[0]
+}
+
+func (f *lzopFieldReader) read16() uint16 {
+	f.readFull(f.buf[:2])
+	return binary.BigEndian.Uint16(f.buf[:2])
+}
+
+func (f *lzopFieldReader) read32() uint32 {
+	f.readFull(f.buf[:4])
+	return binary.BigEndian.Uint32(f.buf[:4])
+}
+
+const (
+	// lzop's block size
+	lzopBlockSize = 256 * 1024
+
+	// what this writer puts in the header: the version of lzop whose
+	// format it follows, that of LZO 2.10, and the version needed to
+	// extract the file
+	lzopLibVersion    = 0x20a0
+	lzopVersionNeeded = 0x0940
+
+	lzopOSUnix = 0x03000000
+)
+
+// An LzopWriter compresses the data written to it into a file in the format
+// of the lzop tool.  Close must be called to finish the file.
+type LzopWriter struct {
+	w           io.Writer
+	z           *Compressor
+	h           LzopHeader
+	buf         []byte
+	wroteHeader bool
+	err         error
+}
+
+// NewLzopWriter returns an LzopWriter compressing with the given lzo1x
+// algorithm.  If h is not nil its Name, Mode and ModTime are recorded in the
+// file's header for lzop -d to restore; the other fields are ignored.  Name
+// must be at most 255 bytes and hold no NUL or '/', and ModTime can't be
+// before 1970.
+func NewLzopWriter(w io.Writer, algorithm LzoAlgorithm, h *LzopHeader) (*LzopWriter, error) {
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	zw := &LzopWriter{
+		w:   w,
+		z:   z,
+		buf: make([]byte, 0, lzopBlockSize),
+	}
+
+	switch algorithm {
+	case Lzo1x_1:
+		zw.h.Method, zw.h.Level = lzopLzo1x_1, 3
+	case Lzo1x_999:
+		zw.h.Method, zw.h.Level = lzopLzo1x_999, byte(z.Level())
+	default:
+		return nil, fmt.Errorf("lzo: lzop files can't use %v", algorithm)
+	}
+
+	zw.h.Version = lzopVersion
+	zw.h.LibVersion = lzopLibVersion
+	zw.h.VersionNeeded = lzopVersionNeeded
+	zw.h.Flags = lzopAdler32D | lzopAdler32C | lzopOSUnix
+
+	if h != nil {
+		if len(h.Name) > 255 || strings.ContainsAny(h.Name, "\x00/") {
+			return nil, &HeaderError{Field: "name", Reason: fmt.Sprintf("invalid file name %q", h.Name)}
+		}
+		if !h.ModTime.IsZero() && h.ModTime.Unix() < 0 {
+			return nil, &HeaderError{Field: "mtime", Reason: "before 1970"}
+		}
+		zw.h.Name = h.Name
+		zw.h.Mode = h.Mode
+		zw.h.ModTime = h.ModTime
+	}
+
+	return zw, nil
+}
+
+// OpenLzopAppend returns an LzopWriter that adds to the lzop file f,
+// which must be open for reading and writing.  The existing blocks are
+// kept: new ones are written over the file's end marker, compressed with
+// the file's method and level and carrying the checksums its flags ask
+// for, and Close writes a new end marker.  Files that use a filter, use
+// a method the writer can't produce, or continue past their end marker,
+// as files of several members do, are refused.
+func OpenLzopAppend(f *os.File) (*LzopWriter, error) {
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	h, err := readLzopHeader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Flags&lzopFilter != 0 {
+		return nil, &HeaderError{Field: "flags", Reason: "can't append to a filtered file"}
+	}
+
+	var z *Compressor
+	switch {
+	case h.Method == lzopLzo1x_1:
+		z, err = NewCompressor(Lzo1x_1)
+	case h.Method == lzopLzo1x_999 && h.Level >= 1 && h.Level <= 9:
+		z, err = NewCompressorLevel(int(h.Level))
+	default:
+		return nil, &HeaderError{Field: "method", Reason: fmt.Sprintf("can't append with method %d level %d", h.Method, h.Level)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// skip to the end marker
+	for {
+		b, err := readLzopBlock(f, h.Flags)
+		if err != nil {
+			return nil, err
+		}
+		if b.dst_len == 0 {
+			break
+		}
+		if _, err := f.Seek(int64(b.src_len), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	end, err := f.Seek(-4, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != end+4 {
+		return nil, errors.New("lzo: data after the end of the lzop file")
+	}
+
+	return &LzopWriter{
+		w:           f,
+		z:           z,
+		h:           *h,
+		buf:         make([]byte, 0, lzopBlockSize),
+		wroteHeader: true,
+	}, nil
+}
+
+// Write buffers p, compressing and writing out each block as it fills.
+func (z *LzopWriter) Write(p []byte) (int, error) {
+
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		n += c
+		p = p[c:]
+
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(z.buf); err != nil {
+				return n, err
+			}
+			z.buf = z.buf[:0]
+		}
+	}
+
+	return n, nil
+}
+
+// Close writes out any buffered data and the end of the file.  It does not
+// close the underlying io.Writer.
+func (z *LzopWriter) Close() error {
+
+	if z.err == errWriterClosed {
+		return nil
+	}
+
+	if len(z.buf) > 0 {
+		if err := z.writeBlock(z.buf); err != nil {
+			return err
+		}
+		z.buf = z.buf[:0]
+	}
+
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+
+	var end [4]byte
+	if _, err := z.w.Write(end[:]); err != nil {
+		z.err = err
+		return err
+	}
+
+	z.err = errWriterClosed
+	return nil
+}
+
+func (z *LzopWriter) writeHeader() error {
+
+	if z.wroteHeader {
+		return z.err
+	}
+	z.wroteHeader = true
+
+	h := &z.h
+
+	var mtime int64
+	if !h.ModTime.IsZero() {
+		mtime = h.ModTime.Unix()
+	}
+
+	var b bytes.Buffer
+	b.Write(lzopMagic[:])
+
+	fw := &lzopFieldWriter{w: &b}
+	fw.write16(h.Version)
+	fw.write16(h.LibVersion)
+	fw.write16(h.VersionNeeded)
+	fw.write8(h.Method)
+	fw.write8(h.Level)
+	fw.write32(h.Flags)
+	fw.write32(h.Mode)
+	fw.write32(uint32(mtime))
+	fw.write32(uint32(mtime >> 32))
+	fw.write8(byte(len(h.Name)))
+	b.WriteString(h.Name)
+	fw.write32(adler32.Checksum(b.Bytes()[len(lzopMagic):]))
+
+	if _, err := z.w.Write(b.Bytes()); err != nil {
+		z.err = err
+	}
+
+	return z.err
+}
+
+func (z *LzopWriter) writeBlock(block []byte) error {
+
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+
+	c, err := z.z.Compress(block)
+	if err != nil {
+		z.err = err
+		return err
+	}
+
+	// blocks that didn't shrink are stored, without a second checksum
+	stored := len(c) >= len(block)
+	if stored {
+		c = block
+	}
+
+	fw := &lzopFieldWriter{w: z.w}
+	fw.write32(uint32(len(block)))
+	fw.write32(uint32(len(c)))
+	fw.writeChecksums(z.h.Flags, lzopAdler32D, lzopCRC32D, block)
+	if !stored {
+		fw.writeChecksums(z.h.Flags, lzopAdler32C, lzopCRC32C, c)
+	}
+
+	if fw.err == nil {
+		_, fw.err = z.w.Write(c)
+	}
+
+	z.err = fw.err
+	return z.err
+}
+
+// lzopFieldWriter writes big-endian header fields, remembering the first
+// error
+type lzopFieldWriter struct {
+	w   io.Writer
+	buf [4]byte
+	err error
+}
+
+func (f *lzopFieldWriter) write(b []byte) {
+	if f.err == nil {
+		_, f.err = f.w.Write(b)
+	}
+}
+
+func (f *lzopFieldWriter) write8(v byte) {
+	f.buf[0] = v
+	f.write(f.buf[:1])
+}
+
+func (f *lzopFieldWriter) write16(v uint16) {
+	binary.BigEndian.PutUint16(f.buf[:2], v)
+	f.write(f.buf[:2])
+}
+
+func (f *lzopFieldWriter) write32(v uint32) {
+	binary.BigEndian.PutUint32(f.buf[:4], v)
+	f.write(f.buf[:4])
+}
+
+// writeChecksums writes whichever of the adler32 and crc32 checksums of
+// data flags asks for, the counterpart of lzopCheck
+func (f *lzopFieldWriter) writeChecksums(flags uint32, adler_flag uint32, crc_flag uint32, data []byte) {
+	if flags&adler_flag != 0 {
+		f.write32(adler32.Checksum(data))
+	}
+	if flags&crc_flag != 0 {
+		f.write32(crc32.ChecksumIEEE(data))
+	}
+}
@@ -0,0 +1,94 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/adler32"
+	"testing"
+)
+
+func TestDecodeLzopBytesImplausibleSize(t *testing.T) {
+
+	var buf bytes.Buffer
+	w, err := NewLzopWriter(&buf, Lzo1x_1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 1000))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	if _, err := DecodeLzopBytes(file); err != nil {
+		t.Fatalf("unmodified file: %v", err)
+	}
+
+	// claim the block holds far more than its few bytes could decompress to
+	r := bytes.NewReader(file)
+	if _, err := readLzopHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	off := len(file) - r.Len()
+	binary.BigEndian.PutUint32(file[off:], lzopMaxBlockSize)
+
+	if _, err := DecodeLzopBytes(file); err != errCorrupt {
+		t.Errorf("got %v, want %v", err, errCorrupt)
+	}
+}
+
+func TestIsCompressedEncode(t *testing.T) {
+
+	blob, err := Encode(bytes.Repeat([]byte("is it compressed? "), 100), Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsCompressed(blob) {
+		t.Error("an Encode blob wasn't recognised")
+	}
+
+	// plain data that happens to start with the two magic bytes
+	text := append([]byte{encodeMagic, encodeVersion}, "plain text that isn't compressed at all"...)
+	if IsCompressed(text) {
+		t.Error("plain data starting with the Encode magic was recognised")
+	}
+
+	unknown := append([]byte(nil), blob...)
+	unknown[2] = 0xff
+	if IsCompressed(unknown) {
+		t.Error("a blob with an unknown algorithm was recognised")
+	}
+
+	huge := append([]byte(nil), blob...)
+	binary.BigEndian.PutUint32(huge[3:], 0xffffffff)
+	if IsCompressed(huge) {
+		t.Error("a blob with an implausible length was recognised")
+	}
+}
+
+func TestLzopHeaderExtraTooLong(t *testing.T) {
+
+	// a version 0x1040 header with an extra field, but no name
+	var hdr []byte
+	hdr = binary.BigEndian.AppendUint16(hdr, lzopVersion)
+	hdr = binary.BigEndian.AppendUint16(hdr, 0x20a0)
+	hdr = binary.BigEndian.AppendUint16(hdr, 0x0940)
+	hdr = append(hdr, lzopLzo1x_1, 5)
+	hdr = binary.BigEndian.AppendUint32(hdr, lzopAdler32D|lzopExtraField)
+	hdr = binary.BigEndian.AppendUint32(hdr, 0o644)
+	hdr = binary.BigEndian.AppendUint64(hdr, 0)
+	hdr = append(hdr, 0)
+
+	file := append(lzopMagic[:], hdr...)
+	file = binary.BigEndian.AppendUint32(file, adler32.Checksum(hdr))
+
+	// claiming 4 GiB of extra field, with nothing behind it
+	file = binary.BigEndian.AppendUint32(file, 0xffffffff)
+
+	_, err := DecodeLzopBytes(file)
+	var he *HeaderError
+	if !errors.As(err, &he) || he.Field != "extra field" {
+		t.Errorf("got %v, want a HeaderError for the extra field", err)
+	}
+}
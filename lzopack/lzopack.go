@@ -19,6 +19,9 @@ import (
 
 var magicHeader = [...]byte{0x00, 0xe9, 0x4c, 0x5a, 0x4f, 0xff, 0x1a}
 
+// the only header flag lzopack knows: the data is followed by its Adler32
+const flagAdler32 = 1
+
 func read8(r io.Reader) uint {
 	var u uint8
 	err := binary.Read(r, binary.BigEndian, &u)
@@ -61,7 +64,7 @@ func compress(in *os.File, out *os.File, level uint, blocksize uint) {
 
 	out.Write(magicHeader[:])
 
-	write32(out, 1)                // flags
+	write32(out, flagAdler32)      // flags
 	write8(out, 1)                 // method
 	write8(out, uint8(level&0xff)) // level
 	write32(out, uint32(blocksize))
@@ -76,7 +79,10 @@ func compress(in *os.File, out *os.File, level uint, blocksize uint) {
 		algorithm = lzo.BestCompression
 	}
 
-	z, _ := lzo.NewCompressor(algorithm)
+	z, err := lzo.NewCompressor(algorithm)
+	if err != nil {
+		fatal("can't compress at level ", level, ": ", err)
+	}
 
 	inb := make([]byte, blocksize)
 
@@ -141,6 +147,10 @@ func decompress(in *os.File, out *os.File) {
 	level := read8(in)
 	blockSize := read32(in)
 
+	if flags&^flagAdler32 != 0 {
+		fatal("header error -- unsupported flags: ", flags)
+	}
+
 	if method != 1 {
 		fatal("header error - unknown compression method: ", method, " (level: ", level, ")")
 	}
@@ -149,7 +159,10 @@ func decompress(in *os.File, out *os.File) {
 		fatal("header error -- invalid block size: ", blockSize)
 	}
 
-	z, _ := lzo.NewCompressor(lzo.Lzo1x_1)
+	z, err := lzo.NewDecompressor(lzo.Lzo1x_1)
+	if err != nil {
+		fatal("can't decompress: ", err)
+	}
 	h := adler32.New()
 	inb := make([]byte, blockSize+blockSize/16+64+3)
 
@@ -187,7 +200,7 @@ func decompress(in *os.File, out *os.File) {
 		h.Write(outb[:sz]) // update hash
 	}
 
-	if flags&1 == 1 {
+	if flags&flagAdler32 != 0 {
 		checksum := read32(in)
 
 		hashb := h.Sum(nil)
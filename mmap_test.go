@@ -0,0 +1,63 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lzo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDecompressAtMmap(t *testing.T) {
+
+	z, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testInputs()[6][:10000]
+	c, err := z.Compress(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decompress straight into a file, after a header it leaves alone
+	const off = 100
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(off + int64(len(in))); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := syscall.Mmap(int(f.Fd()), 0, off+len(in), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		t.Skip("mmap:", err)
+	}
+
+	if _, err := z.DecompressAt(c, m[:off+len(in)-1], off); err != ErrOutputOverrun {
+		t.Errorf("a region one byte short: got %v, want %v", err, ErrOutputOverrun)
+	}
+	if _, err := z.DecompressAt(c, m, len(m)+1); err != ErrLength {
+		t.Errorf("an offset past the end: got %v, want %v", err, ErrLength)
+	}
+
+	n, err := z.DecompressAt(c, m, off)
+	if err != nil || int(n) != len(in) {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if err := syscall.Munmap(m); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:off], make([]byte, off)) || !bytes.Equal(got[off:], in) {
+		t.Error("the file doesn't hold the decompressed data at the offset")
+	}
+}
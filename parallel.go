@@ -0,0 +1,303 @@
+package lzo
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// CompressParallel compresses r into w in the Writer's stream format, using
+// blocks of blockSize bytes.  The blocks are independent, so they are
+// compressed by a pool of workers, each with its own Compressor, and written
+// out in their original order.  If workers is less than 1, GOMAXPROCS
+// workers are used.
+func CompressParallel(w io.Writer, r io.Reader, blockSize int, workers int, algorithm LzoAlgorithm) error {
+	return CompressParallelDeadline(w, r, blockSize, workers, algorithm, 0)
+}
+
+// CompressParallelDeadline is like CompressParallel with Lzo1x_999, but a
+// block that takes longer than deadline to compress is compressed with
+// Lzo1x_1 instead, bounding the time pathological data can take.  Both
+// produce lzo1x data, which the Reader decompresses alike, so the stream
+// needn't record which blocks fell back.  The slow compression can't be
+// interrupted: it carries on in the background after the deadline, using
+// a CPU and its work memory until it finishes, and may outlive the call.
+// At most workers slow compressions run at once, counting those, and a
+// block that finds none free is compressed with Lzo1x_1 straight away.  A
+// deadline of 0 or less disables the fallback.
+func CompressParallelDeadline(w io.Writer, r io.Reader, blockSize int, workers int, algorithm LzoAlgorithm, deadline time.Duration) error {
+
+	if deadline > 0 && algorithm != Lzo1x_999 {
+		return fmt.Errorf("lzo: a compression deadline needs Lzo1x_999, not %v", algorithm)
+	}
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return fmt.Errorf("lzo: invalid block size %d", blockSize)
+	}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return err
+	}
+
+	fast, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		return err
+	}
+
+	type job struct {
+		block []byte
+		c     []byte
+		err   error
+		done  chan struct{}
+	}
+
+	jobs := make(chan *job)
+	// the blocks in input order, bounding how far reading runs ahead
+	queue := make(chan *job, workers)
+	stop := make(chan struct{})
+	defer close(stop)
+	// the slow compressions running, including those past their deadline
+	slots := make(chan struct{}, workers)
+
+	for i := 0; i < workers; i++ {
+		go func(z *Compressor, fast *Compressor) {
+			for j := range jobs {
+				if deadline > 0 {
+					z, j.c, j.err = compressDeadline(z, fast, j.block, deadline, slots)
+				} else {
+					j.c, j.err = z.Compress(j.block)
+				}
+				close(j.done)
+				if z == nil {
+					return
+				}
+			}
+		}(z.Clone(), fast.Clone())
+	}
+
+	var readErr error
+
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+
+		for {
+			block := make([]byte, blockSize)
+			n, err := io.ReadFull(r, block)
+			if n > 0 {
+				j := &job{block: block[:n], done: make(chan struct{})}
+				select {
+				case queue <- j:
+				case <-stop:
+					return
+				}
+				select {
+				case jobs <- j:
+				case <-stop:
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	zw := newWriter(w, z, blockSize)
+
+	for j := range queue {
+		<-j.done
+		if j.err != nil {
+			return j.err
+		}
+		if err := zw.writeCompressed(j.block, j.c); err != nil {
+			return err
+		}
+	}
+
+	// queue is closed only after readErr is set
+	if readErr != nil {
+		return readErr
+	}
+
+	return zw.Close()
+}
+
+// compressDeadline compresses block with z, or with fast if z takes longer
+// than deadline or slots has no room for another slow compression.  Past
+// the deadline z is left to finish in the background, holding its slot,
+// and isn't touched again; the Compressor to use in its place is returned,
+// or nil with the error if there is none.
+func compressDeadline(z *Compressor, fast *Compressor, block []byte, deadline time.Duration, slots chan struct{}) (*Compressor, []byte, error) {
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		c, err := fast.Compress(block)
+		return z, c, err
+	}
+
+	type result struct {
+		c   []byte
+		err error
+	}
+
+	level := z.level
+	done := make(chan result, 1)
+	go func() {
+		c, err := z.Compress(block)
+		<-slots
+		done <- result{c, err}
+	}()
+
+	t := time.NewTimer(deadline)
+	defer t.Stop()
+
+	select {
+	case r := <-done:
+		return z, r.c, r.err
+	case <-t.C:
+		c, err := fast.Compress(block)
+		if err != nil {
+			return nil, nil, err
+		}
+		z, err := NewCompressor(level)
+		return z, c, err
+	}
+}
+
+// DecompressParallel decompresses a stream in the Writer's format from r
+// into w.  The blocks are decompressed by a pool of workers and written out
+// in their original order.  The first error stops the remaining work; an
+// error decompressing a block is returned as a *BlockError.  Like a
+// Reader, it carries on into any streams concatenated after the first.  If
+// workers is less than 1, GOMAXPROCS workers are used.
+func DecompressParallel(w io.Writer, r io.Reader, workers int) error {
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	zr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	type job struct {
+		block int
+		src   []byte
+		dst   []byte
+		err   error
+		done  chan struct{}
+		// end, for the end of a stream, is closed once its last block has
+		// been written
+		end chan struct{}
+	}
+
+	jobs := make(chan *job)
+	// the blocks in stream order, bounding how far reading runs ahead
+	queue := make(chan *job, workers)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				select {
+				case <-stop:
+				default:
+					if len(j.src) != len(j.dst) {
+						j.err = decodeBlock(zr.z, j.src, j.dst)
+					} else {
+						copy(j.dst, j.src)
+					}
+				}
+				close(j.done)
+			}
+		}()
+	}
+
+	var readErr error
+
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+
+		for block := 0; ; {
+			dst_len, src, err := zr.nextBlock(make([]byte, len(zr.in)))
+			if err != nil {
+				readErr = err
+				return
+			}
+
+			// the checksum covers the whole stream, so wait for its last
+			// block to be written before reading the trailer and going on
+			// to the next stream, if there is one
+			if dst_len == 0 {
+				j := &job{end: make(chan struct{})}
+				select {
+				case queue <- j:
+				case <-stop:
+					return
+				}
+				select {
+				case <-j.end:
+				case <-stop:
+					return
+				}
+				if err := zr.readTrailer(); err != io.EOF {
+					readErr = err
+					return
+				}
+				if err := zr.readHeader(); err != nil {
+					if err != io.EOF {
+						readErr = err
+					}
+					return
+				}
+				continue
+			}
+
+			j := &job{block: block, src: src, dst: make([]byte, dst_len), done: make(chan struct{})}
+			block++
+			select {
+			case queue <- j:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for j := range queue {
+		if j.end != nil {
+			close(j.end)
+			continue
+		}
+		<-j.done
+		if j.err != nil {
+			return &BlockError{Block: j.block, Err: j.err}
+		}
+		zr.checksum.Write(j.dst)
+		if _, err := w.Write(j.dst); err != nil {
+			return err
+		}
+	}
+
+	// queue is closed only after readErr is set
+	return readErr
+}
@@ -0,0 +1,104 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCompressDeadline(t *testing.T) {
+
+	fast, err := NewCompressor(Lzo1x_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a Compressor that doesn't finish until release is closed
+	release := make(chan struct{})
+	blocked := func() *Compressor {
+		z, err := NewCompressor(Lzo1x_1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compress := z.compress
+		z.compress = func(b []byte, out []byte, out_size *int, wrkmem []byte) int {
+			<-release
+			return compress(b, out, out_size, wrkmem)
+		}
+		return z
+	}
+
+	in := bytes.Repeat([]byte("deadline "), 1000)
+	check := func(c []byte) {
+		out := make([]byte, len(in))
+		if n, err := fast.DecompressSafe(c, out); err != nil || !bytes.Equal(out[:n], in) {
+			t.Errorf("round trip failed: %v", err)
+		}
+	}
+
+	slots := make(chan struct{}, 1)
+
+	slow := blocked()
+	z, c, err := compressDeadline(slow, fast, in, time.Millisecond, slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z == slow {
+		t.Error("the abandoned Compressor was returned")
+	}
+	check(c)
+
+	// the abandoned compression still holds the only slot, so this one
+	// mustn't wait for its deadline
+	slow = blocked()
+	start := time.Now()
+	z, c, err = compressDeadline(slow, fast, in, time.Hour, slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z != slow || time.Since(start) > time.Minute {
+		t.Error("compressed slowly without a free slot")
+	}
+	check(c)
+
+	// the slot is given back once the background work finishes
+	close(release)
+	slots <- struct{}{}
+}
+
+func TestDecompressParallelMultistream(t *testing.T) {
+
+	ins := [][]byte{testInputs()[6][:5*minBlockSize+100], testInputs()[5][:3*minBlockSize], testInputs()[3]}
+
+	// streams with different checksums and block sizes, one after another
+	var stream, want []byte
+	for i, in := range ins {
+		var buf bytes.Buffer
+		w, err := NewWriterSize(&buf, Lzo1x_1, minBlockSize<<i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.CRC32 = i == 1
+		w.Write(in)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+		want = append(want, in...)
+	}
+
+	var out bytes.Buffer
+	if err := DecompressParallel(&out, bytes.NewReader(stream), 3); err != nil || !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("got %d bytes of %d, %v", out.Len(), len(want), err)
+	}
+
+	// a bad checksum in a later stream is still caught
+	bad := append([]byte(nil), stream...)
+	bad[len(bad)-1] ^= 1
+	var ce *ChecksumError
+	if err := DecompressParallel(io.Discard, bytes.NewReader(bad), 3); !errors.As(err, &ce) {
+		t.Errorf("corrupt checksum in the last stream: got %v", err)
+	}
+}
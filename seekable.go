@@ -0,0 +1,143 @@
+package lzo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// A BlockEntry locates one block of a stream in the Writer's format.
+type BlockEntry struct {
+	// Offset is where the block, starting with its sizes, begins in the
+	// compressed stream
+	Offset int64
+
+	// CompressedSize is the size of the block's data, not counting the
+	// sizes before it
+	CompressedSize uint32
+
+	// UncompressedOffset is where the block's data begins in the
+	// decompressed stream
+	UncompressedOffset int64
+
+	// Size is the uncompressed size of the block
+	Size uint32
+}
+
+// A SeekableReader gives random access to the decompressed contents of a
+// stream in the Writer's format, given an index of its blocks such as the
+// one returned by Writer.Index.  Only the block holding the data being read
+// is decompressed.
+type SeekableReader struct {
+	zr     *Reader
+	ra     io.ReaderAt
+	index  []BlockEntry
+	starts []int64 // uncompressed offset of each block
+	size   int64
+	pos    int64
+	cur    int // the block held in out, or -1
+	in     []byte
+	out    []byte
+}
+
+// NewSeekableReader reads the stream header from ra and returns a
+// SeekableReader for the blocks in index, which must be in stream order.
+func NewSeekableReader(ra io.ReaderAt, index []BlockEntry) (*SeekableReader, error) {
+
+	zr := &Reader{r: io.NewSectionReader(ra, 0, int64(len(magicHeader)+10)), order: binary.BigEndian}
+	if err := zr.readHeader(); err != nil {
+		return nil, noEOF(err)
+	}
+
+	z := &SeekableReader{
+		zr:     zr,
+		ra:     ra,
+		index:  index,
+		starts: make([]int64, len(index)),
+		cur:    -1,
+		in:     zr.in,
+		out:    zr.out,
+	}
+
+	for i, e := range index {
+		if e.Size == 0 || e.Size > uint32(zr.blockSize) {
+			return nil, errCorrupt
+		}
+		z.starts[i] = z.size
+		z.size += int64(e.Size)
+	}
+
+	return z, nil
+}
+
+// Size returns the uncompressed size of the indexed blocks.
+func (z *SeekableReader) Size() int64 {
+	return z.size
+}
+
+// Read reads decompressed data from the current position.
+func (z *SeekableReader) Read(p []byte) (int, error) {
+
+	if z.pos >= z.size {
+		return 0, io.EOF
+	}
+
+	i := sort.Search(len(z.starts), func(i int) bool { return z.starts[i] > z.pos }) - 1
+	if i != z.cur {
+		if err := z.loadBlock(i); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, z.out[z.pos-z.starts[i]:z.index[i].Size])
+	z.pos += int64(n)
+
+	return n, nil
+}
+
+// Seek sets the position for the next Read, in uncompressed bytes.
+func (z *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.pos
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("lzo: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("lzo: negative position")
+	}
+
+	z.pos = offset
+	return offset, nil
+}
+
+func (z *SeekableReader) loadBlock(i int) error {
+
+	z.cur = -1
+
+	e := z.index[i]
+	z.zr.r = io.NewSectionReader(z.ra, e.Offset, 8+int64(len(z.in)))
+
+	dst_len, src, err := z.zr.nextBlock(z.in)
+	if err != nil {
+		return err
+	}
+	if dst_len != e.Size {
+		return errCorrupt
+	}
+
+	if len(src) == int(dst_len) {
+		copy(z.out, src)
+	} else if err := decodeBlock(z.zr.z, src, z.out[:dst_len]); err != nil {
+		return err
+	}
+
+	z.cur = i
+	return nil
+}
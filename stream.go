@@ -0,0 +1,1099 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Writer and Reader use the stream format of the lzopack example
+// distributed with LZO: a header, a series of independently compressed
+// blocks each preceded by its uncompressed and compressed sizes, a zero
+// size marking the end of the blocks and an Adler32 checksum of the
+// uncompressed data, or a CRC32 checksum if the header's flags say so.  A
+// block that didn't shrink is stored as is, which the reader recognises by
+// the two sizes being equal; any other block is compressed, even one that
+// grew.
+
+var magicHeader = [...]byte{0x00, 0xe9, 0x4c, 0x5a, 0x4f, 0xff, 0x1a}
+
+const (
+	// DefaultBlockSize is the block size used by NewWriter
+	DefaultBlockSize = 256 * 1024
+
+	minBlockSize = 1024
+	maxBlockSize = 8 * 1024 * 1024
+
+	// header flags
+	flagAdler32 = 1
+	flagCRC32   = 2
+	// the blocks were compressed with a preset dictionary, whose Adler32
+	// follows the block size in the header
+	flagDict = 4
+	// the flags this package understands; a stream with any other set may
+	// be laid out differently, so it is refused
+	flagKnown = flagAdler32 | flagCRC32 | flagDict
+
+	// header methods; lzopack only knows methodLzo1x
+	methodLzo1x = 1
+	methodLzo2a = 2
+)
+
+var (
+	errWriterClosed = errors.New("lzo: write to closed Writer")
+	errCorrupt      = errors.New("lzo: block size error - data corrupted")
+)
+
+// StreamWriter is the interface shared by Writer and the Writers of
+// compress/flate, compress/gzip and compress/zlib, so code can switch
+// between them.
+type StreamWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// StreamReader is the interface shared by Reader and compress/gzip's
+// Reader.  The flate and zlib Readers are only io.ReadClosers.
+type StreamReader interface {
+	io.ReadCloser
+	Reset(r io.Reader) error
+}
+
+var (
+	_ StreamWriter = (*Writer)(nil)
+	_ StreamReader = (*Reader)(nil)
+)
+
+// A Writer compresses the data written to it into the lzopack stream
+// format.  Close must be called to finish the stream.
+type Writer struct {
+	// KeepIndex makes the Writer record where each block starts, for
+	// Index to return.  It must be set before the first Write.
+	KeepIndex bool
+
+	// CRC32 makes the Writer end the stream with a CRC32 checksum instead
+	// of Adler32, noting the choice in the header.  It must be set before
+	// the first Write.
+	CRC32 bool
+
+	// Progress, if not nil, is called after each block is written with the
+	// total number of bytes taken in and written out so far.
+	Progress func(bytesIn, bytesOut int64)
+
+	// StoreUncompressible, true by default, makes the Writer store a block
+	// as is when compressing didn't shrink it.  With it false a block is
+	// written compressed even if that makes it larger, as it may on random
+	// data by up to blockSize/16 + 67 bytes.  Only a block whose compressed
+	// size equals its size is still stored, since the format can't tell
+	// the two apart.  The lzopack example rejects a block larger
+	// compressed than it is, so a stream written with it false may only
+	// be readable by this package's Reader.
+	StoreUncompressible bool
+
+	// ByteOrder is the byte order of the stream's size and checksum
+	// fields, binary.BigEndian by default as lzopack uses.  A stream
+	// written in another order can only be read by a Reader from
+	// NewReaderByteOrder given the same one; any other Reader would see
+	// nonsense sizes.  It must be set before the first Write.
+	ByteOrder binary.ByteOrder
+
+	w           io.Writer
+	z           *Compressor
+	store       bool
+	dict        []byte
+	blockSize   int
+	buf         []byte
+	out         []byte
+	checksum    hash.Hash32
+	wroteHeader bool
+	total       int64
+	written     int64
+	index       []BlockEntry
+	err         error
+}
+
+// NewWriter returns a Writer compressing with the given algorithm in blocks
+// of DefaultBlockSize.
+func NewWriter(w io.Writer, algorithm LzoAlgorithm) (*Writer, error) {
+	return NewWriterSize(w, algorithm, DefaultBlockSize)
+}
+
+// NewWriterLevel returns a Writer whose level follows compress/gzip: 0
+// stores every block without compressing it, 1 selects Lzo1x_1, the
+// fastest, 2 to 9 select Lzo1x_999 at that level (see
+// NewCompressorLevel), and -1 selects DefaultCompression.  Any other level
+// is an error.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+
+	switch {
+	case level == -1:
+		return NewWriter(w, DefaultCompression)
+	case level == 0:
+		zw, err := NewWriter(w, Lzo1x_1)
+		if err != nil {
+			return nil, err
+		}
+		zw.store = true
+		return zw, nil
+	case level == 1:
+		return NewWriter(w, Lzo1x_1)
+	case level >= 2 && level <= 9:
+		z, err := NewCompressorLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		return newWriter(w, z, DefaultBlockSize), nil
+	}
+
+	return nil, fmt.Errorf("lzo: invalid compression level: %d", level)
+}
+
+// NewWriterDict returns a Writer compressing with Lzo1x_999 at the given
+// level, as for NewCompressorLevel, and the preset dictionary dict.  Data
+// such as many small, similar documents compresses better when the
+// dictionary holds typical content; only its last 48 KiB are used.  The
+// stream can only be read by a Reader from NewReaderDict with the same
+// dictionary, which the header records a checksum of.
+func NewWriterDict(w io.Writer, level int, dict []byte) (*Writer, error) {
+
+	z, err := NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if err := z.useDict(dict); err != nil {
+		return nil, err
+	}
+
+	zw := newWriter(w, z, DefaultBlockSize)
+	zw.dict = dict
+
+	return zw, nil
+}
+
+// NewWriterSize returns a Writer compressing with the given algorithm in
+// blocks of blockSize bytes, which must be between 1 KiB and 8 MiB.
+func NewWriterSize(w io.Writer, algorithm LzoAlgorithm, blockSize int) (*Writer, error) {
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return nil, fmt.Errorf("lzo: invalid block size %d", blockSize)
+	}
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(w, z, blockSize), nil
+}
+
+func newWriter(w io.Writer, z *Compressor, blockSize int) *Writer {
+	return &Writer{
+		StoreUncompressible: true,
+		ByteOrder:           binary.BigEndian,
+		w:                   w,
+		z:                   z,
+		blockSize:           blockSize,
+		checksum:            adler32.New(),
+	}
+}
+
+// StreamCompressBound returns the largest stream a Writer with blocks of
+// blockSize bytes can produce from inputLen bytes, for sizing an output
+// region in advance.  It allows for every block compressing to its worst
+// case under any algorithm, as it may with StoreUncompressible false,
+// plus the header, block sizes, end marker and trailer.  It returns -1 for
+// an invalid block size.
+func StreamCompressBound(inputLen int, blockSize int) int {
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize || inputLen < 0 {
+		return -1
+	}
+
+	// the header, with room for a dictionary checksum, the end marker and
+	// the trailer
+	n := len(magicHeader) + 14 + 4 + 4
+
+	full, rest := inputLen/blockSize, inputLen%blockSize
+	n += full * (8 + lzo2a_output_size(blockSize))
+	if rest > 0 {
+		n += 8 + lzo2a_output_size(rest)
+	}
+
+	return n
+}
+
+// CompressMapped compresses src into dst in the Writer's stream format,
+// using blocks of blockSize bytes.  It is meant for large inputs such as a
+// memory-mapped file: each block is compressed straight from src without
+// being copied, and only one compressed block is held in memory at a time.
+// src must stay valid, and for a mapping stay mapped, until CompressMapped
+// returns.
+func (z *Compressor) CompressMapped(src []byte, dst io.Writer, blockSize int) error {
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return fmt.Errorf("lzo: invalid block size %d", blockSize)
+	}
+
+	w := newWriter(dst, z, blockSize)
+
+	for len(src) > 0 {
+		n := blockSize
+		if n > len(src) {
+			n = len(src)
+		}
+		if err := w.writeBlock(src[:n]); err != nil {
+			return err
+		}
+		src = src[n:]
+	}
+
+	return w.Close()
+}
+
+// CompressRange compresses length bytes of ra starting at off into a
+// stream in the Writer's format, reading them a block of DefaultBlockSize
+// at a time.  A range running past the end of ra returns
+// io.ErrUnexpectedEOF.
+func CompressRange(ra io.ReaderAt, off int64, length int, algorithm LzoAlgorithm) ([]byte, error) {
+
+	if length < 0 {
+		return nil, fmt.Errorf("lzo: invalid range length %d", length)
+	}
+
+	z, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := newWriter(&buf, z, DefaultBlockSize)
+
+	block := make([]byte, DefaultBlockSize)
+	for length > 0 {
+		n := len(block)
+		if n > length {
+			n = length
+		}
+		// ReadAt may return io.EOF along with a full read at the end of ra
+		if m, err := ra.ReadAt(block[:n], off); m < n {
+			return nil, noEOF(err)
+		}
+		if err := w.writeBlock(block[:n]); err != nil {
+			return nil, err
+		}
+		off += int64(n)
+		length -= n
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// CompressPipe returns a pipe that compresses in a goroutine: data written
+// to w can be read from r compressed in the Writer's stream format.  Closing
+// w finishes the stream, after which r returns io.EOF.  An error compressing,
+// including an unsupported algorithm, is returned from both r and w.
+// Either end can abandon the pipe with CloseWithError, which the other end
+// then returns; closing r makes further writes to w fail with
+// io.ErrClosedPipe rather than block.
+func CompressPipe(algorithm LzoAlgorithm) (w *io.PipeWriter, r *io.PipeReader) {
+
+	rawR, rawW := io.Pipe()
+	cR, cW := io.Pipe()
+
+	go func() {
+		zw, err := NewWriter(cW, algorithm)
+		if err == nil {
+			_, err = io.Copy(zw, rawR)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		rawR.CloseWithError(err)
+		cW.CloseWithError(err)
+	}()
+
+	return rawW, cR
+}
+
+// CompressFile compresses the file srcPath into dstPath, which is created
+// or truncated, in the Writer's stream format with blocks of blockSize
+// bytes, as the lzopack example's compress does.  If compressing fails,
+// dstPath is removed rather than left holding a partial stream.
+func CompressFile(dstPath, srcPath string, algorithm LzoAlgorithm, blockSize int) (err error) {
+
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// check the arguments before creating dstPath
+	zw, err := NewWriterSize(nil, algorithm, blockSize)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	zw.Reset(out)
+
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// DecompressFile decompresses srcPath, either an lzop file or an lzopack
+// stream as told by NewAutoReader, into dstPath, which is created or
+// truncated.  The checksums the file carries are verified, and a mismatch
+// is returned as a *ChecksumError.  If decompressing fails, dstPath is
+// removed rather than left holding partial output.
+func DecompressFile(dstPath, srcPath string) (err error) {
+
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := NewAutoReader(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dstPath)
+		}
+	}()
+
+	if _, err := io.Copy(out, zr); err != nil {
+		return err
+	}
+
+	return zr.Close()
+}
+
+// Write buffers p, compressing and writing out each block as it fills.
+func (w *Writer) Write(p []byte) (int, error) {
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.allocBuf()
+
+	n := 0
+	for len(p) > 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		n += c
+		p = p[c:]
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.writeBlock(w.buf); err != nil {
+				return n, err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+
+	return n, nil
+}
+
+// WriteString is like Write, but copies s into the block buffer directly
+// rather than through a []byte conversion.
+func (w *Writer) WriteString(s string) (int, error) {
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.allocBuf()
+
+	n := 0
+	for len(s) > 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], s)
+		w.buf = w.buf[:len(w.buf)+c]
+		n += c
+		s = s[c:]
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.writeBlock(w.buf); err != nil {
+				return n, err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+
+	return n, nil
+}
+
+// WriteByte writes a single byte, which like Write only goes into the
+// block buffer until the block is full.
+func (w *Writer) WriteByte(c byte) error {
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.allocBuf()
+	w.buf = append(w.buf, c)
+
+	if len(w.buf) == cap(w.buf) {
+		if err := w.writeBlock(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+
+	return nil
+}
+
+// Flush compresses and writes out any buffered data as a block of its own,
+// shorter than the block size.
+func (w *Writer) Flush() error {
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.writeBlock(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+
+	return nil
+}
+
+// Reset discards the Writer's state and makes it write a new stream to dst,
+// with the same algorithm and block size.
+func (w *Writer) Reset(dst io.Writer) {
+	w.w = dst
+	w.buf = w.buf[:0]
+	w.checksum = adler32.New()
+	w.wroteHeader = false
+	w.total = 0
+	w.written = 0
+	w.index = nil
+	w.err = nil
+}
+
+// Close writes out any buffered data and the end of the stream.  It does
+// not close the underlying io.Writer.
+func (w *Writer) Close() error {
+
+	if w.err == errWriterClosed {
+		return nil
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.writeBlock(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	w.ByteOrder.PutUint32(trailer[4:], w.checksum.Sum32())
+	if _, err := w.w.Write(trailer[:]); err != nil {
+		w.err = err
+		return err
+	}
+	w.written += int64(len(trailer))
+
+	w.err = errWriterClosed
+	return nil
+}
+
+func (w *Writer) writeHeader() error {
+
+	if w.wroteHeader {
+		return w.err
+	}
+	w.wroteHeader = true
+
+	method, level := streamMethod(w.z)
+
+	flags := uint32(flagAdler32)
+	if w.CRC32 {
+		flags = flagCRC32
+		w.checksum = crc32.NewIEEE()
+	}
+
+	var buf [len(magicHeader) + 14]byte
+	hdr := buf[:len(magicHeader)+10]
+	if w.dict != nil {
+		flags |= flagDict
+		hdr = buf[:]
+		w.ByteOrder.PutUint32(hdr[17:], adler32.Checksum(w.dict))
+	}
+
+	copy(hdr, magicHeader[:])
+	w.ByteOrder.PutUint32(hdr[7:], flags)
+	hdr[11] = method
+	hdr[12] = level
+	w.ByteOrder.PutUint32(hdr[13:], uint32(w.blockSize))
+
+	if _, err := w.w.Write(hdr); err != nil {
+		w.err = err
+	}
+	w.written += int64(len(hdr))
+
+	return w.err
+}
+
+// allocBuf allocates the block buffer on first use, so that callers
+// handing writeBlock whole blocks of their own never pay for it
+func (w *Writer) allocBuf() {
+	if w.buf == nil {
+		w.buf = make([]byte, 0, w.blockSize)
+	}
+}
+
+func (w *Writer) writeBlock(block []byte) error {
+
+	if w.store {
+		return w.writeCompressed(block, block)
+	}
+
+	// one output buffer, big enough for any block, serves every block
+	if w.out == nil {
+		w.out = make([]byte, w.z.output_size(w.blockSize))
+	}
+
+	c, err := w.z.compressTo(block, w.out)
+	if err != nil {
+		w.err = err
+		return err
+	}
+
+	return w.writeCompressed(block, c)
+}
+
+// writeCompressed writes out block, given c, its compressed form
+func (w *Writer) writeCompressed(block []byte, c []byte) error {
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	w.checksum.Write(block)
+
+	// store blocks that didn't shrink as they are
+	if len(c) == len(block) || w.StoreUncompressible && len(c) > len(block) {
+		c = block
+	}
+
+	var hdr [8]byte
+	w.ByteOrder.PutUint32(hdr[0:], uint32(len(block)))
+	w.ByteOrder.PutUint32(hdr[4:], uint32(len(c)))
+
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		w.err = err
+		return err
+	}
+	if _, err := w.w.Write(c); err != nil {
+		w.err = err
+		return err
+	}
+
+	if w.KeepIndex {
+		w.index = append(w.index, BlockEntry{
+			Offset:             w.written,
+			CompressedSize:     uint32(len(c)),
+			UncompressedOffset: w.total,
+			Size:               uint32(len(block)),
+		})
+	}
+	w.total += int64(len(block))
+	w.written += int64(len(hdr) + len(c))
+
+	if w.Progress != nil {
+		w.Progress(w.total, w.written)
+	}
+
+	return nil
+}
+
+// BytesWritten returns how many compressed bytes the Writer has written to
+// its underlying writer so far.  It grows as each block is written, not on
+// every Write, since data is buffered until a block is full; after Close
+// it is the size of the whole stream.
+func (w *Writer) BytesWritten() int64 {
+	return w.written
+}
+
+// Checksum returns the checksum of the data written so far, which Close
+// writes at the end of the stream.  It is the same checksum liblzo's
+// lzo_adler32, or with CRC32 set lzo_crc32, computes.
+func (w *Writer) Checksum() uint32 {
+	return w.checksum.Sum32()
+}
+
+// Index returns an entry for each block written so far if KeepIndex is set,
+// for use with NewSeekableReader.  After Close it covers the whole stream.
+func (w *Writer) Index() []BlockEntry {
+	return w.index
+}
+
+// streamMethod returns the header method and level bytes for a compressor.
+// lzopack writes level 1 for lzo1x_1 and 9 for lzo1x_999.
+func streamMethod(z *Compressor) (byte, byte) {
+	switch {
+	case z.level == Lzo1x_1:
+		return methodLzo1x, 1
+	case z.level == Lzo2a_999:
+		return methodLzo2a, 9
+	case z.compress_level != 0:
+		return methodLzo1x, byte(z.compress_level)
+	}
+	return methodLzo1x, 9
+}
+
+// A Reader decompresses a stream in the lzopack format.
+type Reader struct {
+	// Verify makes the Reader check the checksum at the end of the
+	// stream, returning a *ChecksumError from Read if it doesn't match
+	// the decompressed data.  NewReader sets it to true.
+	Verify bool
+
+	// MaxDecompressedSize, if positive, limits the total size of the
+	// decompressed stream.  A block that would take it past the limit
+	// makes Read return ErrTooLarge before the block is decompressed.
+	MaxDecompressedSize int64
+
+	// MaxRatio, if positive, limits how many times its compressed size
+	// any one block may decompress to.  A block that would expand
+	// further makes Read return ErrTooLarge before it is read, so a
+	// decompression bomb is caught at its first block.  Real data rarely
+	// comes near 100.
+	MaxRatio int
+
+	r           io.Reader
+	z           *Compressor
+	dict        []byte
+	order       binary.ByteOrder
+	flags       uint32
+	blockSize   int
+	in          []byte
+	out         []byte
+	pending     []byte
+	checksum    hash.Hash32
+	total       int64
+	consumed    int64
+	multistream bool
+	err         error
+}
+
+// NewReader reads the stream header from r and returns a Reader for the
+// rest of the stream.
+func NewReader(r io.Reader) (*Reader, error) {
+
+	z := &Reader{
+		Verify:      true,
+		r:           r,
+		order:       binary.BigEndian,
+		multistream: true,
+	}
+
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+// NewReaderByteOrder is like NewReader for a stream written by a Writer
+// whose ByteOrder was set to order.  A stream whose header only makes sense
+// in the other byte order is rejected with a *HeaderError.
+func NewReaderByteOrder(r io.Reader, order binary.ByteOrder) (*Reader, error) {
+
+	z := &Reader{
+		Verify:      true,
+		r:           r,
+		order:       order,
+		multistream: true,
+	}
+
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+// NewReaderDict is like NewReader for a stream written by NewWriterDict,
+// which needs the same dictionary to decompress.  A stream whose header
+// records a different dictionary is rejected with a *HeaderError rather
+// than decompressed to garbage.
+func NewReaderDict(r io.Reader, dict []byte) (*Reader, error) {
+
+	z := &Reader{
+		Verify:      true,
+		r:           r,
+		order:       binary.BigEndian,
+		dict:        dict,
+		multistream: true,
+	}
+
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+func (z *Reader) readHeader() error {
+
+	var hdr [len(magicHeader) + 10]byte
+	if err := z.readFull(hdr[:]); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hdr[:len(magicHeader)], magicHeader[:]) {
+		return &HeaderError{Field: "magic", Reason: "not an lzopack stream"}
+	}
+
+	if !headerValid(hdr[:], z.order) && headerValid(hdr[:], otherOrder(z.order)) {
+		return &HeaderError{Field: "byte order", Reason: "the stream uses the other byte order"}
+	}
+
+	z.flags = z.order.Uint32(hdr[7:])
+	if z.flags&^flagKnown != 0 {
+		return &HeaderError{Field: "flags", Reason: fmt.Sprintf("unsupported flags %#x", z.flags&^flagKnown)}
+	}
+	if z.flags&flagCRC32 != 0 {
+		z.checksum = crc32.NewIEEE()
+	} else {
+		z.checksum = adler32.New()
+	}
+
+	var algorithm LzoAlgorithm
+	switch hdr[11] {
+	case methodLzo1x:
+		algorithm = Lzo1x_1
+	case methodLzo2a:
+		algorithm = Lzo2a_999
+	default:
+		return &HeaderError{Field: "method", Reason: fmt.Sprintf("unknown compression method %d", hdr[11])}
+	}
+
+	blockSize := z.order.Uint32(hdr[13:])
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return &HeaderError{Field: "block size", Reason: fmt.Sprintf("%d out of range", blockSize)}
+	}
+	z.blockSize = int(blockSize)
+
+	var err error
+	if z.z, err = NewCompressor(algorithm); err != nil {
+		return err
+	}
+
+	if z.flags&flagDict != 0 {
+		if err := z.readDict(); err != nil {
+			return err
+		}
+	}
+
+	z.in = make([]byte, z.z.output_size(z.blockSize))
+	z.out = make([]byte, z.blockSize)
+
+	return nil
+}
+
+// headerValid reports whether the flags and block size of a stream header
+// make sense when read in the given byte order
+func headerValid(hdr []byte, order binary.ByteOrder) bool {
+	flags := order.Uint32(hdr[7:])
+	blockSize := order.Uint32(hdr[13:])
+	return flags&^flagKnown == 0 && blockSize >= minBlockSize && blockSize <= maxBlockSize
+}
+
+// otherOrder returns the byte order that isn't order
+func otherOrder(order binary.ByteOrder) binary.ByteOrder {
+	if order == binary.ByteOrder(binary.BigEndian) {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// readDict reads the dictionary checksum that follows the header of a
+// stream compressed with one, and checks it against the Reader's
+func (z *Reader) readDict() error {
+
+	if z.dict == nil {
+		return &HeaderError{Field: "dictionary", Reason: "stream needs a preset dictionary"}
+	}
+
+	var sum [4]byte
+	if err := z.readFull(sum[:]); err != nil {
+		return noEOF(err)
+	}
+
+	if z.order.Uint32(sum[:]) != adler32.Checksum(z.dict) {
+		return &HeaderError{Field: "dictionary", Reason: "dictionary doesn't match the stream's"}
+	}
+
+	return z.z.useDict(z.dict)
+}
+
+// Read reads decompressed data from the stream.  A block is returned only
+// once all of it has been read and decompressed, so a truncated stream
+// never yields a partial block.  io.EOF means the end marker, and the
+// trailer if any, were read; a stream cut short anywhere before that,
+// even on a block boundary, returns io.ErrUnexpectedEOF.
+func (z *Reader) Read(p []byte) (int, error) {
+
+	for len(z.pending) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+
+		// carry on into the next stream, if there is one
+		if z.err == io.EOF && z.multistream {
+			z.err = z.readHeader()
+		}
+	}
+
+	n := copy(p, z.pending)
+	z.pending = z.pending[n:]
+
+	return n, nil
+}
+
+// Close does not close the underlying io.Reader.  If the whole stream was
+// read and its checksum didn't match, Close returns the *ChecksumError that
+// Read did.
+func (z *Reader) Close() error {
+	if _, ok := z.err.(*ChecksumError); ok {
+		return z.err
+	}
+	return nil
+}
+
+// Reset discards the Reader's state and reads the header of a new stream
+// from r.
+func (z *Reader) Reset(r io.Reader) error {
+	z.r = r
+	z.pending = nil
+	z.total = 0
+	z.consumed = 0
+	z.multistream = true
+	z.err = z.readHeader()
+	return z.err
+}
+
+// CompressedBytesRead returns how many bytes the Reader has read from its
+// underlying reader, for finding where the stream ends in a larger
+// container.  The Reader reads exactly what the stream holds, so after
+// Read returns io.EOF with Multistream(false) this is the stream's size.
+func (z *Reader) CompressedBytesRead() int64 {
+	return z.consumed
+}
+
+// readFull reads exactly len(b) bytes from the underlying reader, counting
+// them
+func (z *Reader) readFull(b []byte) error {
+	n, err := io.ReadFull(z.r, b)
+	z.consumed += int64(n)
+	return err
+}
+
+// Multistream controls whether the Reader carries on into any streams
+// concatenated after the first, as it does by default.  With ok false, Read
+// returns io.EOF at the end of the current stream and leaves the underlying
+// reader positioned just after it, ready for a call to Reset.
+func (z *Reader) Multistream(ok bool) {
+	z.multistream = ok
+}
+
+func (z *Reader) readBlock() error {
+
+	dst_len, src, err := z.nextBlock(z.in)
+	if err != nil {
+		return err
+	}
+
+	// the end of the blocks
+	if dst_len == 0 {
+		return z.readTrailer()
+	}
+
+	if len(src) == int(dst_len) {
+		// stored uncompressed
+		z.pending = src
+	} else {
+		if err := decodeBlock(z.z, src, z.out[:dst_len]); err != nil {
+			return err
+		}
+		z.pending = z.out[:dst_len]
+	}
+
+	z.checksum.Write(z.pending)
+
+	return nil
+}
+
+// nextBlock reads the sizes of the next block and its data, which it returns
+// in a slice of in.  It returns a dst_len of zero at the end of the blocks.
+func (z *Reader) nextBlock(in []byte) (uint32, []byte, error) {
+
+	var hdr [8]byte
+	if err := z.readFull(hdr[:4]); err != nil {
+		return 0, nil, noEOF(err)
+	}
+
+	dst_len := z.order.Uint32(hdr[:4])
+	if dst_len == 0 {
+		return 0, nil, nil
+	}
+
+	if err := z.readFull(hdr[4:]); err != nil {
+		return 0, nil, noEOF(err)
+	}
+
+	src_len := z.order.Uint32(hdr[4:])
+
+	// a compressed block can be larger than its data, up to the worst
+	// case that in is sized for
+	if src_len == 0 || uint64(src_len) > uint64(len(in)) || dst_len > uint32(z.blockSize) {
+		return 0, nil, errCorrupt
+	}
+
+	if z.MaxRatio > 0 && uint64(dst_len) > uint64(src_len)*uint64(z.MaxRatio) {
+		return 0, nil, ErrTooLarge
+	}
+
+	z.total += int64(dst_len)
+	if z.MaxDecompressedSize > 0 && z.total > z.MaxDecompressedSize {
+		return 0, nil, ErrTooLarge
+	}
+
+	if err := z.readFull(in[:src_len]); err != nil {
+		return 0, nil, noEOF(err)
+	}
+
+	return dst_len, in[:src_len], nil
+}
+
+// decodeBlock decompresses src into dst, which must be exactly the block's
+// uncompressed size
+func decodeBlock(z *Compressor, src []byte, dst []byte) error {
+
+	out_size, err := z.decompress_safe(src, dst)
+	if err != 0 {
+		return Errno(err)
+	}
+	if out_size != uint(len(dst)) {
+		return errCorrupt
+	}
+
+	return nil
+}
+
+func (z *Reader) readTrailer() error {
+
+	kind := "adler32"
+	switch {
+	case z.flags&flagCRC32 != 0:
+		kind = "crc32"
+	case z.flags&flagAdler32 == 0:
+		return io.EOF
+	}
+
+	var trailer [4]byte
+	if err := z.readFull(trailer[:]); err != nil {
+		return noEOF(err)
+	}
+
+	expected := z.order.Uint32(trailer[:])
+	if actual := z.checksum.Sum32(); z.Verify && expected != actual {
+		return &ChecksumError{Expected: expected, Actual: actual, Kind: kind}
+	}
+
+	return io.EOF
+}
+
+// DecompressToWriter decompresses src, a complete stream in the Writer's
+// format, to w one block at a time, so only a block's worth of output is
+// ever held in memory.  The stream must decompress to exactly
+// totalUncompressed bytes: a stream that would grow past it returns
+// ErrTooLarge before the excess is written, and a shorter one returns an
+// error once it ends.
+func DecompressToWriter(src []byte, totalUncompressed int, w io.Writer) error {
+
+	z, err := NewReader(bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := z.readBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if z.total > int64(totalUncompressed) {
+			return ErrTooLarge
+		}
+
+		if _, err := w.Write(z.pending); err != nil {
+			return err
+		}
+	}
+
+	if z.total != int64(totalUncompressed) {
+		return fmt.Errorf("lzo: stream decompressed to %d bytes, expected %d", z.total, totalUncompressed)
+	}
+
+	return nil
+}
+
+// noEOF turns an io.EOF inside the stream into io.ErrUnexpectedEOF
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
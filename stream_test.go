@@ -0,0 +1,205 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWriterLevelStore(t *testing.T) {
+
+	in := bytes.Repeat([]byte("store me as I am "), 1000)
+
+	var buf bytes.Buffer
+	w, err := NewWriterLevel(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// compressible data must still be written as it is
+	if !bytes.Contains(buf.Bytes(), in) {
+		t.Errorf("level 0 compressed the data to %d bytes", buf.Len())
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(out, in) {
+		t.Errorf("round trip failed: %v", err)
+	}
+}
+
+func TestWriteByte(t *testing.T) {
+
+	in := testInputs()[6]
+
+	stream := func(write func(w *Writer)) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriterSize(&buf, Lzo1x_1, minBlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(w)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	// bytes written one at a time must give the same stream as one Write
+	want := stream(func(w *Writer) { w.Write(in) })
+	got := stream(func(w *Writer) {
+		for _, c := range in {
+			if err := w.WriteByte(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("WriteByte wrote %d bytes, Write %d", len(got), len(want))
+	}
+}
+
+func BenchmarkWriteByte(b *testing.B) {
+
+	in := testInputs()[6]
+	w, err := NewWriter(io.Discard, Lzo1x_1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(in)))
+	for i := 0; i < b.N; i++ {
+		w.Reset(io.Discard)
+		for _, c := range in {
+			w.WriteByte(c)
+		}
+		w.Close()
+	}
+}
+
+func TestWriterAllocs(t *testing.T) {
+
+	in := testInputs()[6][:minBlockSize]
+	w, err := NewWriterSize(io.Discard, Lzo1x_1, minBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(in)
+
+	// once the buffers are there, a block costs no more than compressing
+	// it and the 8 byte block header handed to the underlying writer
+	out := make([]byte, w.z.output_size(len(in)))
+	each := testing.AllocsPerRun(10, func() { w.z.compressTo(in, out) }) + 1
+	n := testing.AllocsPerRun(10, func() { w.Write(in) })
+	if n > each {
+		t.Errorf("%v allocations per block, want at most %v", n, each)
+	}
+
+	// and writing whole blocks of the caller's own needs no block buffer
+	var buf bytes.Buffer
+	z, _ := NewCompressor(Lzo1x_1)
+	mw := newWriter(&buf, z, minBlockSize)
+	if err := mw.writeBlock(in); err != nil {
+		t.Fatal(err)
+	}
+	if mw.buf != nil {
+		t.Error("writing a block allocated the Write buffer")
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+
+	in := testInputs()[6][:5*minBlockSize+100]
+
+	var buf bytes.Buffer
+	w, err := NewWriterSize(&buf, Lzo1x_1, minBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(in)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	// cut anywhere short of the end, the stream must fail rather than
+	// end early, having given back only whole blocks.  No input at all is
+	// io.EOF, as for compress/gzip.
+	for n := 1; n < len(stream); n++ {
+		var out []byte
+		r, err := NewReader(bytes.NewReader(stream[:n]))
+		if err == nil {
+			out, err = io.ReadAll(r)
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("cut at %d: got %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+		whole := len(out)%minBlockSize == 0 || len(out) == len(in)
+		if !whole || !bytes.Equal(out, in[:len(out)]) {
+			t.Fatalf("cut at %d: read %d bytes that aren't whole blocks of the input", n, len(out))
+		}
+	}
+}
+
+func TestBytesWritten(t *testing.T) {
+
+	var buf bytes.Buffer
+	w, err := NewWriterSize(&buf, Lzo1x_1, minBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the count follows the underlying writer at every step, however
+	// much is still buffered
+	for _, p := range [][]byte{testInputs()[6][:3000], {'x'}, testInputs()[5][:5000]} {
+		w.Write(p)
+		if n := w.BytesWritten(); n != int64(buf.Len()) {
+			t.Errorf("after a write: BytesWritten %d, wrote %d", n, buf.Len())
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.BytesWritten(); n != int64(buf.Len()) {
+		t.Errorf("after Close: BytesWritten %d, wrote %d", n, buf.Len())
+	}
+}
+
+func TestCompressPipeClose(t *testing.T) {
+
+	// a reader that gives up must unblock the writer
+	w, r := CompressPipe(Lzo1x_1)
+	r.Close()
+
+	done := make(chan error)
+	go func() {
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			_, err = w.Write(make([]byte, DefaultBlockSize))
+		}
+		done <- err
+	}()
+	if err := <-done; err != io.ErrClosedPipe {
+		t.Errorf("writing after the reader closed: got %v, want %v", err, io.ErrClosedPipe)
+	}
+
+	// and a writer that gives up reaches the reader
+	w, r = CompressPipe(Lzo1x_1)
+	abort := errors.New("abort")
+	w.CloseWithError(abort)
+	if _, err := io.ReadAll(r); err != abort {
+		t.Errorf("reading after the writer aborted: got %v, want %v", err, abort)
+	}
+}
@@ -0,0 +1,114 @@
+package lzo
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// A Codec names a compression format for Transcode.
+type Codec int
+
+const (
+	CodecNone  Codec = iota // uncompressed data
+	CodecGzip               // compress/gzip
+	CodecZlib               // compress/zlib
+	CodecFlate              // raw deflate, compress/flate
+	CodecLzo                // the stream format of Writer and Reader
+	CodecLzop               // the file format of the lzop tool
+)
+
+var codecNames = map[Codec]string{
+	CodecNone:  "none",
+	CodecGzip:  "gzip",
+	CodecZlib:  "zlib",
+	CodecFlate: "flate",
+	CodecLzo:   "lzo",
+	CodecLzop:  "lzop",
+}
+
+func (c Codec) String() string {
+	if s, ok := codecNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("Codec(%d)", int(c))
+}
+
+// Transcode decompresses src, in the format in, and recompresses it into
+// dst in the format out, in a single pass without holding the whole data
+// in memory.  LZO output uses Lzo1x_1, which favours speed as LZO is
+// usually chosen for.  Checksums in the input are verified.
+func Transcode(dst io.Writer, src io.Reader, in, out Codec) error {
+
+	r, err := codecReader(bufio.NewReader(src), in)
+	if err != nil {
+		return err
+	}
+
+	w, err := codecWriter(dst, out)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func codecReader(r io.Reader, c Codec) (io.ReadCloser, error) {
+	switch c {
+	case CodecNone:
+		return io.NopCloser(r), nil
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZlib:
+		return zlib.NewReader(r)
+	case CodecFlate:
+		return flate.NewReader(r), nil
+	case CodecLzo:
+		return NewReader(r)
+	case CodecLzop:
+		zr, _, err := NewLzopReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+	return nil, fmt.Errorf("lzo: unknown codec %v", c)
+}
+
+// nopWriteCloser gives uncompressed output the Close the others have
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func codecWriter(w io.Writer, c Codec) (io.WriteCloser, error) {
+	switch c {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZlib:
+		return zlib.NewWriter(w), nil
+	case CodecFlate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case CodecLzo:
+		return NewWriter(w, Lzo1x_1)
+	case CodecLzop:
+		return NewLzopWriter(w, Lzo1x_1, nil)
+	}
+	return nil, fmt.Errorf("lzo: unknown codec %v", c)
+}
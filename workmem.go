@@ -0,0 +1,26 @@
+package lzo
+
+import "sync"
+
+// Compressors take their work memory from pools of buffers, one for each
+// size an algorithm needs, and give it back on Close, so code creating
+// many short-lived Compressors doesn't allocate work memory each time.
+var workmemPools sync.Map // int -> *sync.Pool
+
+// getWorkmem returns a work memory buffer of size bytes, from the pool if
+// one is free.  The contents are left over from its last use; the
+// compressors initialize what they read.
+func getWorkmem(size int) []byte {
+	if p, ok := workmemPools.Load(size); ok {
+		if b, ok := p.(*sync.Pool).Get().(*[]byte); ok {
+			return *b
+		}
+	}
+	return make([]byte, size)
+}
+
+// putWorkmem returns b, which came from getWorkmem, to its pool
+func putWorkmem(b []byte) {
+	p, _ := workmemPools.LoadOrStore(len(b), new(sync.Pool))
+	p.(*sync.Pool).Put(&b)
+}